@@ -0,0 +1,315 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// DefaultBatchSize is the number of calls sent per request unless
+// WithBatchSize is used.
+const DefaultBatchSize = 100
+
+// batchSingleConcurrency is the number of calls in flight when a batch is
+// sent as single calls.
+const batchSingleConcurrency = 8
+
+// Batch collects calls to send as JSON-RPC 2.0 batch arrays. Calls are split
+// into requests of the client batch size. When the server doesn't take batch
+// arrays the calls are sent as single requests, 8 at a time.
+type Batch struct {
+	client *Client
+	items  []*BatchItem
+}
+
+// BatchItem is a single call in a Batch. Err and Result are set by Send.
+type BatchItem struct {
+	Method string
+	Params any
+	Result json.RawMessage
+	Err    error
+
+	id     int64
+	result any
+}
+
+// BatchError is returned by Batch.Send when one or more calls failed.
+type BatchError struct {
+	Failed []*BatchItem
+	Total  int
+}
+
+func (e *BatchError) Error() string {
+	msg := strconv.Itoa(len(e.Failed)) + " of " + strconv.Itoa(e.Total) + " batch calls failed"
+	if len(e.Failed) > 0 {
+		msg += " (first: " + e.Failed[0].Method + ": " + e.Failed[0].Err.Error() + ")"
+	}
+
+	return msg
+}
+
+func (c *Client) NewBatch() *Batch {
+	return &Batch{client: c}
+}
+
+// Add queues method with params. If result is not nil, the call result is
+// decoded into it by Send.
+func (b *Batch) Add(method string, params any, result any) *BatchItem {
+	item := &BatchItem{
+		Method: method,
+		Params: params,
+		result: result,
+	}
+
+	b.items = append(b.items, item)
+	return item
+}
+
+func (b *Batch) DispatcherAdd(groupval string, addressval string) *BatchItem {
+	return b.Add("dispatcher.add", []any{groupval, addressval}, nil)
+}
+
+func (b *Batch) DispatcherRemove(groupval string, addressval string) *BatchItem {
+	return b.Add("dispatcher.remove", []any{groupval, addressval}, nil)
+}
+
+func (b *Batch) HtableDelete(tableval string, keyval string) *BatchItem {
+	return b.Add("htable.delete", []any{tableval, keyval}, nil)
+}
+
+func (b *Batch) HtableSetInt(tableval string, keyval string, valval string) *BatchItem {
+	return b.Add("htable.seti", []any{tableval, keyval, valval}, nil)
+}
+
+// HtableSet uses htable.seti or htable.sets depending on the value type.
+func (b *Batch) HtableSet(tableval string, keyval string, value HtableValue) *BatchItem {
+	method, param := value.setParams()
+	return b.Add(method, []any{tableval, keyval, param}, nil)
+}
+
+func (b *Batch) HtableSetString(tableval string, keyval string, valval string) *BatchItem {
+	return b.Add("htable.sets", []any{tableval, keyval, valval}, nil)
+}
+
+func (b *Batch) Items() []*BatchItem {
+	return b.items
+}
+
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+// Send posts all queued calls. The returned error is a *BatchError when
+// Kamailio rejected some of the calls; each item carries its own Err.
+func (b *Batch) Send(ctx context.Context) error {
+	size := b.client.batchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	for _, item := range b.items {
+		item.Err = nil
+		item.Result = nil
+	}
+
+	for start := 0; start < len(b.items); start += size {
+		end := start + size
+		if end > len(b.items) {
+			end = len(b.items)
+		}
+
+		chunk := b.items[start:end]
+		if err := ctx.Err(); err != nil {
+			setBatchErr(b.items[start:], err)
+			return err
+		}
+
+		if err := b.client.sendBatch(ctx, chunk); err != nil {
+			setBatchErr(b.items[start:], err)
+			return err
+		}
+	}
+
+	var failed []*BatchItem
+	for _, item := range b.items {
+		if item.Err != nil {
+			failed = append(failed, item)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed, Total: len(b.items)}
+	}
+
+	return nil
+}
+
+func (c *Client) sendBatch(ctx context.Context, items []*BatchItem) error {
+	if c.noBatch.Load() {
+		return c.sendBatchSingle(ctx, items)
+	}
+
+	requests := make([]rpcRequest, len(items))
+	byId := make(map[string]*BatchItem, len(items))
+	for i, item := range items {
+		item.id = nextId()
+		requests[i] = rpcRequest{
+			Jsonrpc: "2.0",
+			Method:  item.Method,
+			Params:  item.Params,
+			Id:      item.id,
+		}
+
+		byId[strconv.FormatInt(item.id, 10)] = item
+	}
+
+	sendJson, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.SendJson(ctx, string(sendJson))
+	if errors.Is(err, ErrBatchUnsupported) {
+		c.noBatch.Store(true)
+		return c.sendBatchSingle(ctx, items)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var responses []rpcResponse
+	trimmed := bytes.TrimSpace([]byte(raw))
+	if len(trimmed) > 0 && trimmed[0] != '[' {
+		resp, err := parseResponse(trimmed)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := byId[string(bytes.Trim(resp.Id, `"`))]; ok {
+			// a plain response to one of the calls, not to the array
+			responses = []rpcResponse{*resp}
+		} else if resp.Error != nil {
+			// an error for the whole array: jsonrpcs doesn't take batch
+			// arrays, send the calls one by one from now on.
+			c.noBatch.Store(true)
+			return c.sendBatchSingle(ctx, items)
+		} else {
+			return errors.New("invalid batch response from kamailio")
+		}
+	} else if err := json.Unmarshal(trimmed, &responses); err != nil {
+		return errors.New("invalid response from kamailio: " + err.Error())
+	}
+
+	for i := range responses {
+		resp := &responses[i]
+		item, ok := byId[string(bytes.Trim(resp.Id, `"`))]
+		if !ok {
+			continue
+		}
+
+		delete(byId, string(bytes.Trim(resp.Id, `"`)))
+		if resp.Error != nil {
+			item.Err = resp.Error
+			continue
+		}
+
+		item.Result = resp.Result
+		item.Err = decodeResult(resp.Result, item.result)
+	}
+
+	for id, item := range byId {
+		item.Err = errors.New("no response from kamailio for request id " + id)
+	}
+
+	return nil
+}
+
+// sendBatchSingle sends the calls one by one, at most
+// batchSingleConcurrency at a time, for servers without batch support.
+func (c *Client) sendBatchSingle(ctx context.Context, items []*BatchItem) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+
+	sem := make(chan struct{}, batchSingleConcurrency)
+	for _, item := range items {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := first != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(item *BatchItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			resp, _, err := c.call(ctx, item.Method, item.Params)
+			var rpcErr *RPCError
+			if errors.As(err, &rpcErr) {
+				item.Err = err
+				return
+			}
+
+			if err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+
+				mu.Unlock()
+				return
+			}
+
+			item.Result = resp.Result
+			item.Err = decodeResult(resp.Result, item.result)
+		}(item)
+	}
+
+	wg.Wait()
+	return first
+}
+
+func setBatchErr(items []*BatchItem, err error) {
+	for _, item := range items {
+		if item.Err == nil && item.Result == nil {
+			item.Err = err
+		}
+	}
+}
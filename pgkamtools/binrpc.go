@@ -0,0 +1,528 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// binrpc is the protocol of the ctl module, as used by kamcmd.
+//
+// header: | magic 4b | version 4b | flags 4b | len_len-1 2b | cookie_len-1 2b | len | cookie |
+// record: | size flag 1b | size or size_len 3b | type 4b | [size] | value |
+const (
+	binrpcMagic   = 0xA
+	binrpcVersion = 0x1
+
+	binrpcReq       = 0
+	binrpcReply     = 1
+	binrpcFaultFlag = 2
+
+	binrpcTypeInt    = 0
+	binrpcTypeStr    = 1
+	binrpcTypeDouble = 2
+	binrpcTypeStruct = 3
+	binrpcTypeArray  = 4
+	binrpcTypeAvp    = 5
+	binrpcTypeBytes  = 6
+
+	binrpcMaxBody = 1 << 24
+)
+
+// binrpcTransport translates json-rpc requests to binrpc and the replies
+// back, so the rest of the client does not know the difference.
+type binrpcTransport struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+type binrpcJsonResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type binrpcJsonRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Id     json.RawMessage   `json:"id"`
+}
+
+func (t *binrpcTransport) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, t.network, t.address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	trimmed := bytes.TrimSpace(request)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var requests []binrpcJsonRequest
+		if err := json.Unmarshal(trimmed, &requests); err != nil {
+			return nil, err
+		}
+
+		responses := make([]binrpcJsonResponse, 0, len(requests))
+		for _, req := range requests {
+			resp, err := binrpcCall(conn, req)
+			if err != nil {
+				return nil, ctxErr(ctx, err)
+			}
+
+			responses = append(responses, resp)
+		}
+
+		return json.Marshal(responses)
+	}
+
+	var req binrpcJsonRequest
+	if err := json.Unmarshal(trimmed, &req); err != nil {
+		return nil, err
+	}
+
+	resp, err := binrpcCall(conn, req)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	return json.Marshal(resp)
+}
+
+func binrpcCall(conn io.ReadWriter, req binrpcJsonRequest) (binrpcJsonResponse, error) {
+	resp := binrpcJsonResponse{Jsonrpc: "2.0", Id: req.Id}
+	if len(resp.Id) == 0 {
+		resp.Id = json.RawMessage("null")
+	}
+
+	params := make([]any, 0, len(req.Params))
+	for _, raw := range req.Params {
+		var param any
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&param); err != nil {
+			return resp, err
+		}
+
+		params = append(params, param)
+	}
+
+	cookie := uint32(nextId())
+	packet, err := binrpcEncodeRequest(cookie, req.Method, params)
+	if err != nil {
+		return resp, err
+	}
+
+	if _, err := conn.Write(packet); err != nil {
+		return resp, err
+	}
+
+	flags, replyCookie, body, err := binrpcReadPacket(conn)
+	if err != nil {
+		return resp, err
+	}
+
+	if replyCookie != cookie {
+		return resp, fmt.Errorf("binrpc cookie mismatch: sent %d, received %d", cookie, replyCookie)
+	}
+
+	values, err := binrpcDecodeBody(body)
+	if err != nil {
+		return resp, err
+	}
+
+	if flags&binrpcFaultFlag != 0 {
+		rpcErr := &RPCError{Code: 500, Message: "binrpc fault"}
+		if len(values) > 0 {
+			if code, ok := values[0].(int32); ok {
+				rpcErr.Code = int(code)
+			}
+		}
+
+		if len(values) > 1 {
+			if msg, ok := values[1].(string); ok {
+				rpcErr.Message = msg
+			}
+		}
+
+		resp.Error = rpcErr
+		return resp, nil
+	}
+
+	switch len(values) {
+	case 0:
+		resp.Result = nil
+	case 1:
+		resp.Result = values[0]
+	default:
+		resp.Result = values
+	}
+
+	return resp, nil
+}
+
+// binrpcIntLen returns the number of bytes needed for i, as kamailio does.
+func binrpcIntLen(i uint32) int {
+	size := 4
+	for ; size > 0 && i&0xff000000 == 0; size-- {
+		i <<= 8
+	}
+
+	return size
+}
+
+func binrpcPutInt(buf *bytes.Buffer, i uint32, size int) {
+	for s := size - 1; s >= 0; s-- {
+		buf.WriteByte(byte(i >> (uint(s) * 8)))
+	}
+}
+
+func binrpcWriteRecord(buf *bytes.Buffer, typ byte, value []byte) {
+	size := len(value)
+	if size < 8 {
+		buf.WriteByte(byte(size<<4) | typ)
+	} else {
+		sizeLen := binrpcIntLen(uint32(size))
+		buf.WriteByte(byte((sizeLen|8)<<4) | typ)
+		binrpcPutInt(buf, uint32(size), sizeLen)
+	}
+
+	buf.Write(value)
+}
+
+func binrpcWriteInt(buf *bytes.Buffer, typ byte, i int32) {
+	var value bytes.Buffer
+	binrpcPutInt(&value, uint32(i), binrpcIntLen(uint32(i)))
+	binrpcWriteRecord(buf, typ, value.Bytes())
+}
+
+func binrpcWriteString(buf *bytes.Buffer, s string) {
+	binrpcWriteRecord(buf, binrpcTypeStr, append([]byte(s), 0))
+}
+
+func binrpcWriteValue(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		binrpcWriteString(buf, "")
+	case string:
+		binrpcWriteString(buf, v)
+	case bool:
+		if v {
+			binrpcWriteInt(buf, binrpcTypeInt, 1)
+		} else {
+			binrpcWriteInt(buf, binrpcTypeInt, 0)
+		}
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("binrpc int out of range: %d", v)
+		}
+
+		binrpcWriteInt(buf, binrpcTypeInt, int32(v))
+	case int32:
+		binrpcWriteInt(buf, binrpcTypeInt, v)
+	case int64:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("binrpc int out of range: %d", v)
+		}
+
+		binrpcWriteInt(buf, binrpcTypeInt, int32(v))
+	case float64:
+		// doubles travel as int(value * 1000)
+		binrpcWriteInt(buf, binrpcTypeDouble, int32(v*1000))
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return binrpcWriteValue(buf, i)
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+
+		return binrpcWriteValue(buf, f)
+	default:
+		return fmt.Errorf("unsupported binrpc param type %T", value)
+	}
+
+	return nil
+}
+
+func binrpcEncodeRequest(cookie uint32, method string, params []any) ([]byte, error) {
+	var body bytes.Buffer
+	binrpcWriteString(&body, method)
+	for _, param := range params {
+		if err := binrpcWriteValue(&body, param); err != nil {
+			return nil, err
+		}
+	}
+
+	if body.Len() > binrpcMaxBody {
+		return nil, errors.New("binrpc request too large")
+	}
+
+	var packet bytes.Buffer
+	lenLen := binrpcIntLen(uint32(body.Len()))
+	if lenLen == 0 {
+		lenLen = 1
+	}
+
+	cookieLen := binrpcIntLen(cookie)
+	if cookieLen == 0 {
+		cookieLen = 1
+	}
+
+	packet.WriteByte(binrpcMagic<<4 | binrpcVersion)
+	packet.WriteByte(byte(binrpcReq<<4 | (lenLen-1)<<2 | (cookieLen - 1)))
+	binrpcPutInt(&packet, uint32(body.Len()), lenLen)
+	binrpcPutInt(&packet, cookie, cookieLen)
+	packet.Write(body.Bytes())
+	return packet.Bytes(), nil
+}
+
+func binrpcGetInt(b []byte) uint32 {
+	var i uint32
+	for _, c := range b {
+		i = i<<8 | uint32(c)
+	}
+
+	return i
+}
+
+func binrpcReadPacket(r io.Reader) (byte, uint32, []byte, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if hdr[0]>>4 != binrpcMagic || hdr[0]&0x0f != binrpcVersion {
+		return 0, 0, nil, errors.New("invalid binrpc header")
+	}
+
+	flags := hdr[1] >> 4
+	lenLen := int(hdr[1]>>2&0x03) + 1
+	cookieLen := int(hdr[1]&0x03) + 1
+	rest := make([]byte, lenLen+cookieLen)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, 0, nil, err
+	}
+
+	bodyLen := binrpcGetInt(rest[:lenLen])
+	cookie := binrpcGetInt(rest[lenLen:])
+	if bodyLen > binrpcMaxBody {
+		return 0, 0, nil, errors.New("binrpc reply too large")
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return flags, cookie, body, nil
+}
+
+type binrpcDecoder struct {
+	buf []byte
+	pos int
+}
+
+var errBinrpcEnd = errors.New("binrpc end of struct or array")
+
+func binrpcDecodeBody(body []byte) ([]any, error) {
+	d := &binrpcDecoder{buf: body}
+	var values []any
+	for d.pos < len(d.buf) {
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (d *binrpcDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, errors.New("binrpc record truncated")
+	}
+
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// header reads a record header and returns its type and value size. The end
+// marker of a struct or array is returned as errBinrpcEnd.
+func (d *binrpcDecoder) header() (byte, int, error) {
+	h, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	typ := h[0] & 0x0f
+	size := int(h[0] >> 4 & 0x07)
+	if h[0]&0x80 != 0 {
+		if size == 0 {
+			return typ, 0, errBinrpcEnd
+		}
+
+		sizeBytes, err := d.next(size)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		size = int(binrpcGetInt(sizeBytes))
+	}
+
+	return typ, size, nil
+}
+
+func (d *binrpcDecoder) value() (any, error) {
+	typ, size, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.body(typ, size)
+}
+
+func (d *binrpcDecoder) body(typ byte, size int) (any, error) {
+	switch typ {
+	case binrpcTypeInt, binrpcTypeDouble:
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+
+		// negative values always use all 4 bytes.
+		i := binrpcGetInt(b)
+		if typ == binrpcTypeDouble {
+			return float64(int32(i)) / 1000, nil
+		}
+
+		return int32(i), nil
+	case binrpcTypeStr, binrpcTypeBytes:
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(bytes.TrimRight(b, "\x00")), nil
+	case binrpcTypeStruct:
+		return d.structValue()
+	case binrpcTypeArray:
+		return d.arrayValue()
+	default:
+		return nil, fmt.Errorf("unknown binrpc record type %d", typ)
+	}
+}
+
+// structValue reads avp members until the end marker. Repeated names, which
+// kamailio uses for lists inside structs, are collected into a slice.
+func (d *binrpcDecoder) structValue() (map[string]any, error) {
+	members := map[string]any{}
+	for {
+		typ, size, err := d.header()
+		if err == errBinrpcEnd {
+			return members, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if typ != binrpcTypeAvp {
+			return nil, fmt.Errorf("unexpected binrpc record type %d in struct", typ)
+		}
+
+		name, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		key := string(bytes.TrimRight(name, "\x00"))
+		if existing, ok := members[key]; ok {
+			if list, ok := existing.([]any); ok {
+				members[key] = append(list, value)
+			} else {
+				members[key] = []any{existing, value}
+			}
+
+			continue
+		}
+
+		members[key] = value
+	}
+}
+
+func (d *binrpcDecoder) arrayValue() ([]any, error) {
+	values := []any{}
+	for {
+		value, err := d.value()
+		if err == errBinrpcEnd {
+			return values, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+}
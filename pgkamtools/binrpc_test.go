@@ -0,0 +1,295 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// binrpc records written by hand, so the tests don't depend on the encoder
+// they check.
+func binrpcTestRecord(typ byte, value []byte) []byte {
+	if len(value) < 8 {
+		return append([]byte{byte(len(value)<<4) | typ}, value...)
+	}
+
+	return append([]byte{0x90 | typ, byte(len(value))}, value...)
+}
+
+func binrpcTestStr(s string) []byte {
+	return binrpcTestRecord(binrpcTypeStr, append([]byte(s), 0))
+}
+
+func binrpcTestAvp(name string, value []byte) []byte {
+	return append(binrpcTestRecord(binrpcTypeAvp, append([]byte(name), 0)), value...)
+}
+
+func binrpcTestPacket(flags byte, cookie uint32, body []byte) []byte {
+	// 1 byte length, 4 byte cookie
+	packet := []byte{0xA1, flags<<4 | 0<<2 | 3, byte(len(body)), byte(cookie >> 24), byte(cookie >> 16), byte(cookie >> 8), byte(cookie)}
+	return append(packet, body...)
+}
+
+type binrpcTestRequest struct {
+	header []byte
+	cookie uint32
+	body   []byte
+}
+
+// serveBinrpc is a stand-in for the ctl module. It reads each request and
+// writes the reply returned by handle.
+func serveBinrpc(t *testing.T, handle func(req binrpcTestRequest) []byte) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				for {
+					hdr := make([]byte, 2)
+					if _, err := io.ReadFull(conn, hdr); err != nil {
+						return
+					}
+
+					lenLen := int(hdr[1]>>2&3) + 1
+					cookieLen := int(hdr[1]&3) + 1
+					rest := make([]byte, lenLen+cookieLen)
+					if _, err := io.ReadFull(conn, rest); err != nil {
+						return
+					}
+
+					body := make([]byte, binrpcGetInt(rest[:lenLen]))
+					if _, err := io.ReadFull(conn, body); err != nil {
+						return
+					}
+
+					req := binrpcTestRequest{
+						header: append(hdr, rest...),
+						cookie: binrpcGetInt(rest[lenLen:]),
+						body:   body,
+					}
+
+					conn.Write(handle(req))
+				}
+			}()
+		}
+	}()
+
+	return "binrpc://" + ln.Addr().String()
+}
+
+func TestBinrpcEncodeRequest(t *testing.T) {
+	packet, err := binrpcEncodeRequest(0x01020304, "core.echo", []any{0, -1, 0x01020304, 1.5, "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte{0x91, 10}
+	body = append(body, "core.echo\x00"...)
+	body = append(body, 0x00)                         // 0 has no value bytes
+	body = append(body, 0x40, 0xff, 0xff, 0xff, 0xff) // negative uses 4 bytes
+	body = append(body, 0x40, 0x01, 0x02, 0x03, 0x04) // 4 byte int
+	body = append(body, 0x22, 0x05, 0xdc)             // 1.5 as double, 1500
+	body = append(body, 0x21, 's', 0x00)
+
+	want := []byte{0xA1, 0x03, byte(len(body)), 0x01, 0x02, 0x03, 0x04}
+	want = append(want, body...)
+	if !bytes.Equal(packet, want) {
+		t.Fatalf("packet\n% x\nwant\n% x", packet, want)
+	}
+}
+
+func TestBinrpcEncodeRequestLengths(t *testing.T) {
+	// a body over 255 bytes needs a 2 byte length, a small cookie 1 byte
+	packet, err := binrpcEncodeRequest(7, "core.echo", []any{strings.Repeat("x", 300)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if packet[1] != 1<<2|0 {
+		t.Fatalf("header flags byte %#x, want len_len 2 and cookie_len 1", packet[1])
+	}
+
+	if size := int(packet[2])<<8 | int(packet[3]); size != len(packet)-5 {
+		t.Fatalf("body length %d, want %d", size, len(packet)-5)
+	}
+
+	if packet[4] != 7 {
+		t.Fatalf("cookie %d, want 7", packet[4])
+	}
+
+	if _, err := binrpcEncodeRequest(1, "x", []any{int64(1) << 40}); err == nil {
+		t.Fatal("expected an error for an int out of the int32 range")
+	}
+}
+
+func TestBinrpcTypedReply(t *testing.T) {
+	var reply []byte
+	reply = append(reply, 0x00)                                                      // 0
+	reply = append(reply, 0x40, 0xff, 0xff, 0xff, 0xfb)                              // -5
+	reply = append(reply, 0x40, 0x12, 0x34, 0x56, 0x78)                              // 4 bytes
+	reply = append(reply, binrpcTestRecord(binrpcTypeDouble, []byte{0x09, 0xc4})...) // 2.5
+	reply = append(reply, binrpcTestStr("kamailio")...)
+	reply = append(reply, binrpcTypeStruct)
+	reply = append(reply, binrpcTestAvp("name", binrpcTestStr("a"))...)
+	reply = append(reply, binrpcTestAvp("item", []byte{0x10, 1})...)
+	reply = append(reply, binrpcTestAvp("item", []byte{0x10, 2})...)
+	reply = append(reply, binrpcTestAvp("item", []byte{0x10, 3})...)
+	reply = append(reply, 0x80|binrpcTypeStruct)
+	reply = append(reply, binrpcTypeArray, 0x10, 1)
+	reply = append(reply, binrpcTestStr("x")...)
+	reply = append(reply, binrpcTypeArray, 0x80|binrpcTypeArray) // empty nested array
+	reply = append(reply, 0x80|binrpcTypeArray)
+
+	var method string
+	url := serveBinrpc(t, func(req binrpcTestRequest) []byte {
+		if req.header[0] != 0xA1 {
+			t.Errorf("magic/version byte %#x", req.header[0])
+		}
+
+		values, err := binrpcDecodeBody(req.body)
+		if err == nil && len(values) > 0 {
+			method, _ = values[0].(string)
+		}
+
+		return binrpcTestPacket(binrpcReply, req.cookie, reply)
+	})
+
+	c, err := NewClient(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result []any
+	if err := c.Call(context.Background(), "core.echo", []any{"x"}, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != "core.echo" {
+		t.Errorf("method %q", method)
+	}
+
+	want := []any{
+		float64(0),
+		float64(-5),
+		float64(0x12345678),
+		2.5,
+		"kamailio",
+		map[string]any{"name": "a", "item": []any{float64(1), float64(2), float64(3)}},
+		[]any{float64(1), "x", []any{}},
+	}
+
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result\n%#v\nwant\n%#v", result, want)
+	}
+}
+
+func TestBinrpcFault(t *testing.T) {
+	url := serveBinrpc(t, func(req binrpcTestRequest) []byte {
+		body := append([]byte{0x20, 0x01, 0x94}, binrpcTestStr("no such command")...)
+		return binrpcTestPacket(binrpcFaultFlag, req.cookie, body)
+	})
+
+	c, err := NewClient(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Call(context.Background(), "core.nope", nil, nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("got %v, want *RPCError", err)
+	}
+
+	if rpcErr.Code != 404 || rpcErr.Message != "no such command" {
+		t.Fatalf("got %d %q", rpcErr.Code, rpcErr.Message)
+	}
+}
+
+func TestBinrpcCookieMismatch(t *testing.T) {
+	url := serveBinrpc(t, func(req binrpcTestRequest) []byte {
+		return binrpcTestPacket(binrpcReply, req.cookie+1, binrpcTestStr("ok"))
+	})
+
+	c, err := NewClient(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Call(context.Background(), "core.uptime", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "cookie mismatch") {
+		t.Fatalf("got %v, want a cookie mismatch", err)
+	}
+}
+
+func TestBinrpcBatch(t *testing.T) {
+	url := serveBinrpc(t, func(req binrpcTestRequest) []byte {
+		values, _ := binrpcDecodeBody(req.body)
+		if len(values) > 1 && values[1] == "fail" {
+			body := append([]byte{0x20, 0x01, 0xf4}, binrpcTestStr("failed")...)
+			return binrpcTestPacket(binrpcFaultFlag, req.cookie, body)
+		}
+
+		return binrpcTestPacket(binrpcReply, req.cookie, binrpcTestStr("ok"))
+	})
+
+	c, err := NewClient(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := c.NewBatch()
+	ok := batch.HtableDelete("t", "a")
+	failed := batch.Add("htable.delete", []any{"fail"}, nil)
+	err = batch.Send(context.Background())
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Failed) != 1 {
+		t.Fatalf("got %v, want one failed item", err)
+	}
+
+	if ok.Err != nil || failed.Err == nil {
+		t.Fatalf("item errors %v, %v", ok.Err, failed.Err)
+	}
+}
@@ -0,0 +1,249 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CfgVar is one variable of cfg.list.
+type CfgVar struct {
+	Group string `json:"group"`
+	Name  string `json:"name"`
+}
+
+// CfgGet returns a cfg variable, an int64 for int variables and a string
+// otherwise.
+func (c *Client) CfgGet(ctx context.Context, group string, name string) (any, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, "cfg.get", []any{group, name}, &raw); err != nil {
+		return nil, err
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return numberInt(number), nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return nil, errors.New("unexpected cfg.get result: " + string(raw))
+	}
+
+	return str, nil
+}
+
+// CfgSet sets a cfg variable. value is an int or a string, matching the type
+// of the variable.
+func (c *Client) CfgSet(ctx context.Context, group string, name string, value any) error {
+	return c.Call(ctx, "cfg.set", []any{group, name, value}, nil)
+}
+
+// CfgList returns the cfg variables, only those of group if not empty.
+func (c *Client) CfgList(ctx context.Context, group string) ([]CfgVar, error) {
+	var params []any
+	if group != "" {
+		params = append(params, group)
+	}
+
+	lines, err := callList[string](ctx, c, "cfg.list", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// lines are "group: name"
+	vars := make([]CfgVar, 0, len(lines))
+	for _, line := range lines {
+		g, n, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		vars = append(vars, CfgVar{Group: strings.TrimSpace(g), Name: strings.TrimSpace(n)})
+	}
+
+	return vars, nil
+}
+
+// CoreDebug returns the core debug level (corex.debug). Kamailio answers
+// with {debug}, or {old, new} when it was given a level; older versions
+// print a bare number.
+func (c *Client) CoreDebug(ctx context.Context) (int, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, "corex.debug", nil, &raw); err != nil {
+		return 0, err
+	}
+
+	var level int
+	if err := json.Unmarshal(raw, &level); err == nil {
+		return level, nil
+	}
+
+	var reply struct {
+		Debug *int `json:"debug"`
+		New   *int `json:"new"`
+	}
+
+	if err := json.Unmarshal(raw, &reply); err == nil {
+		if reply.Debug != nil {
+			return *reply.Debug, nil
+		}
+
+		if reply.New != nil {
+			return *reply.New, nil
+		}
+	}
+
+	return 0, errors.New("unexpected corex.debug result: " + string(raw))
+}
+
+// CoreSetDebug sets the core debug level (corex.debug).
+func (c *Client) CoreSetDebug(ctx context.Context, level int) error {
+	return c.Call(ctx, "corex.debug", []any{level}, nil)
+}
+
+// DebuggerLogLevel sets the cfg trace log level of the debugger module
+// (dbg.ll) for the process pid, or all processes when pid is 0.
+func (c *Client) DebuggerLogLevel(ctx context.Context, level int, pid int) error {
+	params := []any{level}
+	if pid != 0 {
+		params = append(params, pid)
+	}
+
+	return c.Call(ctx, "dbg.ll", params, nil)
+}
+
+// DebuggerModLevel sets the log level of a module (dbg.mod_level). It needs
+// mod_level_mode enabled in the debugger module.
+func (c *Client) DebuggerModLevel(ctx context.Context, module string, level int) error {
+	return c.Call(ctx, "dbg.mod_level", []any{module, level}, nil)
+}
+
+// DebugScope is a raised core debug level, see RaiseDebug.
+type DebugScope struct {
+	client   *Client
+	previous int
+	timer    *time.Timer
+	once     sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// RaiseDebug sets the core debug level to level and restores the previous
+// level after duration. The restore doesn't use ctx, so it happens even when
+// ctx is cancelled; call Restore to end the scope early.
+func (c *Client) RaiseDebug(ctx context.Context, level int, duration time.Duration) (*DebugScope, error) {
+	previous, err := c.CoreDebug(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.CoreSetDebug(ctx, level); err != nil {
+		// kamailio may have set the level before ctx ended, put the
+		// previous one back without ctx
+		restoreCtx, cancel := context.WithTimeout(context.Background(), restoreTimeout(c))
+		c.CoreSetDebug(restoreCtx, previous)
+		cancel()
+		return nil, err
+	}
+
+	s := &DebugScope{
+		client:   c,
+		previous: previous,
+		timer:    time.NewTimer(duration),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-s.timer.C:
+			s.Restore()
+		case <-s.done:
+		}
+	}()
+
+	return s, nil
+}
+
+// Previous returns the level that is restored.
+func (s *DebugScope) Previous() int {
+	return s.previous
+}
+
+// Restore sets the previous level now. Only the first call restores, later
+// calls return its result.
+func (s *DebugScope) Restore() error {
+	s.once.Do(func() {
+		s.timer.Stop()
+
+		// retry, a debug level left raised fills the logs
+		for attempt := 0; attempt < 3; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Second)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout(s.client))
+			s.err = s.client.CoreSetDebug(ctx, s.previous)
+			cancel()
+			if s.err == nil {
+				break
+			}
+		}
+
+		close(s.done)
+	})
+
+	<-s.done
+	return s.err
+}
+
+// Done is closed once the previous level has been restored.
+func (s *DebugScope) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the result of the restore once Done is closed.
+func (s *DebugScope) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func restoreTimeout(c *Client) time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+
+	return DefaultTimeout
+}
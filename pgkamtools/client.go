@@ -0,0 +1,305 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is used by NewClient when no WithTimeout option is given.
+const DefaultTimeout = 10 * time.Second
+
+// Client holds the connection settings for a single Kamailio node. A Client
+// is safe for concurrent use and should be reused across calls.
+type Client struct {
+	url        string
+	rpc        Transport
+	replyDir   string
+	httpClient *http.Client
+	transport  http.RoundTripper
+	tlsConfig  *tls.Config
+	timeout    time.Duration
+	username   string
+	password   string
+	userAgent  string
+	batchSize  int
+	noBatch    atomic.Bool
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets the overall timeout of each request. Zero means no
+// timeout beyond the context passed to each call.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for https urls.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithIgnoreCert skips verification of the Kamailio certificate, like the
+// SendJsonhttpIgnoreCert functions.
+func WithIgnoreCert() Option {
+	return func(c *Client) {
+		c.tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+}
+
+// WithBasicAuth sends basic auth credentials with every request.
+func WithBasicAuth(username string, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithUserAgent overrides the default "pgrtools" user agent.
+func WithUserAgent(useragent string) Option {
+	return func(c *Client) {
+		c.userAgent = useragent
+	}
+}
+
+// WithTransport sets a custom http.RoundTripper. WithTLSConfig is ignored
+// when a transport is given.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+// WithHTTPClient uses an existing http.Client as is. WithTimeout,
+// WithTLSConfig and WithTransport are ignored when a client is given.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+// WithBatchSize sets how many calls a Batch puts in a single request.
+func WithBatchSize(size int) Option {
+	return func(c *Client) {
+		c.batchSize = size
+	}
+}
+
+// WithRPCTransport sends requests through a custom Transport instead of the
+// one selected by the url scheme.
+func WithRPCTransport(transport Transport) Option {
+	return func(c *Client) {
+		c.rpc = transport
+	}
+}
+
+// WithReplyDir sets the directory where the client creates its reply socket
+// or fifo for unix:// and fifo:// urls. For fifo:// it must match the
+// fifo_reply_dir modparam of jsonrpcs (default /tmp).
+func WithReplyDir(dir string) Option {
+	return func(c *Client) {
+		c.replyDir = dir
+	}
+}
+
+// NewClient returns a Client for the Kamailio rpc url. The scheme selects the
+// transport:
+//
+//	http://localhost/RPC                        jsonrpcs over xhttp
+//	unix:///run/kamailio/kamailio_rpc.sock      jsonrpcs datagram socket
+//	fifo:///run/kamailio/kamailio_rpc.fifo      jsonrpcs fifo
+//	binrpc://127.0.0.1:2049                     ctl module binrpc over tcp
+//	binrpc+unix:///run/kamailio/kamailio_ctl    ctl module binrpc over a unix socket
+func NewClient(urlval string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(urlval)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		url:       urlval,
+		replyDir:  os.TempDir(),
+		timeout:   DefaultTimeout,
+		userAgent: "pgrtools",
+		batchSize: DefaultBatchSize,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.rpc != nil {
+		return c, nil
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+		if parsed.Host == "" {
+			return nil, errors.New("invalid kamailio url: " + urlval)
+		}
+
+		c.rpc = c.newHttpTransport()
+	case "unix":
+		if parsed.Path == "" {
+			return nil, errors.New("invalid kamailio url: " + urlval)
+		}
+
+		c.rpc = &unixTransport{path: parsed.Path, replyDir: c.replyDir, timeout: c.timeout}
+	case "fifo":
+		if parsed.Path == "" {
+			return nil, errors.New("invalid kamailio url: " + urlval)
+		}
+
+		c.rpc = &fifoTransport{path: parsed.Path, replyDir: c.replyDir, timeout: c.timeout}
+	case "binrpc":
+		if parsed.Host == "" {
+			return nil, errors.New("invalid kamailio url: " + urlval)
+		}
+
+		c.rpc = &binrpcTransport{network: "tcp", address: parsed.Host, timeout: c.timeout}
+	case "binrpc+unix":
+		if parsed.Path == "" {
+			return nil, errors.New("invalid kamailio url: " + urlval)
+		}
+
+		c.rpc = &binrpcTransport{network: "unix", address: parsed.Path, timeout: c.timeout}
+	default:
+		return nil, errors.New("unsupported kamailio url scheme: " + parsed.Scheme)
+	}
+
+	return c, nil
+}
+
+func (c *Client) newHttpTransport() *httpTransport {
+	if c.httpClient == nil {
+		transport := c.transport
+		if transport == nil {
+			transport = http.DefaultTransport
+			if c.tlsConfig != nil {
+				tr := http.DefaultTransport.(*http.Transport).Clone()
+				tr.TLSClientConfig = c.tlsConfig
+				transport = tr
+			}
+		}
+
+		c.httpClient = &http.Client{
+			Transport: transport,
+			Timeout:   c.timeout,
+		}
+	}
+
+	return &httpTransport{
+		client:    c.httpClient,
+		url:       c.url,
+		userAgent: c.userAgent,
+		username:  c.username,
+		password:  c.password,
+	}
+}
+
+// defaultClient keeps the behavior of the package level functions, which
+// have always gone through http.DefaultClient.
+func defaultClient(urlval string) *Client {
+	c := &Client{
+		url:        urlval,
+		httpClient: http.DefaultClient,
+		userAgent:  "pgrtools",
+		batchSize:  DefaultBatchSize,
+	}
+
+	c.rpc = c.newHttpTransport()
+	return c
+}
+
+// URL returns the Kamailio rpc url of the client.
+func (c *Client) URL() string {
+	return c.url
+}
+
+// SendJson sends a raw json string to Kamailio and returns the raw response.
+func (c *Client) SendJson(ctx context.Context, jsonstr string) (string, error) {
+	resp, err := c.rpc.RoundTrip(ctx, []byte(jsonstr))
+	if err != nil {
+		return "", err
+	}
+
+	return string(resp), nil
+}
+
+func postJson(ctx context.Context, client *http.Client, urlstr string, jsonstr string, useragent string, username string, password string) (string, error) {
+	body, err := postJsonStream(ctx, client, urlstr, jsonstr, useragent, username, password)
+	if err != nil {
+		return "", err
+	}
+
+	defer body.Close()
+	curlBody, err := io.ReadAll(body)
+	if err != nil {
+		return "error", err
+	}
+
+	return string(curlBody), nil
+}
+
+func postJsonStream(ctx context.Context, client *http.Client, urlstr string, jsonstr string, useragent string, username string, password string) (io.ReadCloser, error) {
+	// send json to url
+	sendbody := strings.NewReader(jsonstr)
+	req, err := http.NewRequestWithContext(ctx, "POST", urlstr, sendbody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = http.Header{
+		"Content-Type": {"application/json"},
+		"Accept":       {"application/json"},
+		"User-Agent":   {useragent},
+	}
+
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
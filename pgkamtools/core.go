@@ -0,0 +1,222 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+func (c *Client) Uptime(ctx context.Context) (string, error) {
+	results, err := c.CallRaw(ctx, "core.uptime", nil)
+	if err != nil {
+		return "", err
+	}
+
+	return UptimeParse(results)
+}
+
+func (c *Client) Version(ctx context.Context) (string, error) {
+	results, err := c.CallRaw(ctx, "core.version", nil)
+	if err != nil {
+		return "", err
+	}
+
+	return VersionParse(results)
+}
+
+// ShmMem is the shared memory usage of core.shmmem, in bytes.
+type ShmMem struct {
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`
+	Used      uint64 `json:"used"`
+	RealUsed  uint64 `json:"real_used"`
+	MaxUsed   uint64 `json:"max_used"`
+	Fragments uint64 `json:"fragments"`
+}
+
+func (c *Client) ShmMem(ctx context.Context) (ShmMem, error) {
+	var mem ShmMem
+	err := c.Call(ctx, "core.shmmem", nil, &mem)
+	return mem, err
+}
+
+// Process is one kamailio process of core.ps / core.psx.
+type Process struct {
+	Index       int    `json:"index"`
+	PID         int    `json:"pid"`
+	Description string `json:"description"`
+}
+
+// PkgStat is the private memory usage of one process (pkg.stats), in bytes.
+type PkgStat struct {
+	Entry       int    `json:"entry"`
+	PID         int    `json:"pid"`
+	Rank        int    `json:"rank"`
+	Used        uint64 `json:"used"`
+	Free        uint64 `json:"free"`
+	RealUsed    uint64 `json:"real_used"`
+	TotalSize   uint64 `json:"total_size"`
+	TotalFrags  uint64 `json:"total_frags"`
+	Description string `json:"desc"`
+}
+
+// CoreInfo is the build information of core.info.
+type CoreInfo struct {
+	Version  string `json:"version"`
+	ID       string `json:"id"`
+	Compiler string `json:"compiler"`
+	Compiled string `json:"compiled"`
+	Flags    string `json:"flags"`
+}
+
+// TCPInfo is the tcp connection usage of core.tcp_info.
+type TCPInfo struct {
+	Readers              int    `json:"readers"`
+	MaxConnections       int    `json:"max_connections"`
+	MaxTLSConnections    int    `json:"max_tls_connections"`
+	OpenedConnections    int    `json:"opened_connections"`
+	OpenedTLSConnections int    `json:"opened_tls_connections"`
+	WriteQueuedBytes     uint64 `json:"write_queued_bytes"`
+}
+
+// ListenSocket is one listen socket of core.sockets_list.
+type ListenSocket struct {
+	Proto     string `json:"proto"`
+	Address   string `json:"address"`
+	IPAddress string `json:"ipaddress,omitempty"`
+	Port      int    `json:"port"`
+	Mcast     bool   `json:"mcast"`
+	MHomed    bool   `json:"mhomed"`
+	Name      string `json:"sockname,omitempty"`
+	Advertise string `json:"advertise,omitempty"`
+}
+
+type listenSocketJson struct {
+	Proto     string `json:"proto"`
+	Address   string `json:"address"`
+	IPAddress string `json:"ipaddress"`
+	Port      any    `json:"port"`
+	Mcast     string `json:"mcast"`
+	MHomed    string `json:"mhomed"`
+	Name      string `json:"sockname"`
+	Advertise string `json:"advertise"`
+}
+
+// Processes returns the kamailio processes (core.ps).
+func (c *Client) Processes(ctx context.Context) ([]Process, error) {
+	var raw []any
+	if err := c.Call(ctx, "core.ps", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	// core.ps is a flat list of pid, description pairs
+	processes := make([]Process, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		pid, _ := strconv.Atoi(fmt.Sprint(raw[i]))
+		processes = append(processes, Process{
+			Index:       i / 2,
+			PID:         pid,
+			Description: fmt.Sprint(raw[i+1]),
+		})
+	}
+
+	return processes, nil
+}
+
+// ProcessesExtended returns the kamailio processes with their index
+// (core.psx).
+func (c *Client) ProcessesExtended(ctx context.Context) ([]Process, error) {
+	list, err := callList[struct {
+		Index       int    `json:"IDX"`
+		PID         int    `json:"PID"`
+		Description string `json:"DSC"`
+	}](ctx, c, "core.psx", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]Process, 0, len(list))
+	for _, p := range list {
+		processes = append(processes, Process(p))
+	}
+
+	return processes, nil
+}
+
+func (c *Client) PkgStats(ctx context.Context) ([]PkgStat, error) {
+	return callList[PkgStat](ctx, c, "pkg.stats", nil)
+}
+
+func (c *Client) Info(ctx context.Context) (CoreInfo, error) {
+	var info CoreInfo
+	err := c.Call(ctx, "core.info", nil, &info)
+	return info, err
+}
+
+// TCPInfo returns core.tcp_info. It fails when tcp is disabled.
+func (c *Client) TCPInfo(ctx context.Context) (TCPInfo, error) {
+	var info TCPInfo
+	err := c.Call(ctx, "core.tcp_info", nil, &info)
+	return info, err
+}
+
+// Sockets returns the listen sockets (core.sockets_list).
+func (c *Client) Sockets(ctx context.Context) ([]ListenSocket, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, "core.sockets_list", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	list, err := decodeRepeated[listenSocketJson](raw, "socket")
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := make([]ListenSocket, 0, len(list))
+	for _, s := range list {
+		port, _ := strconv.Atoi(fmt.Sprint(s.Port))
+		sockets = append(sockets, ListenSocket{
+			Proto:     s.Proto,
+			Address:   s.Address,
+			IPAddress: s.IPAddress,
+			Port:      port,
+			Mcast:     s.Mcast == "yes",
+			MHomed:    s.MHomed == "yes",
+			Name:      s.Name,
+			Advertise: s.Advertise,
+		})
+	}
+
+	return sockets, nil
+}
+
+// Modules returns the names of the loaded modules (core.modules).
+func (c *Client) Modules(ctx context.Context) ([]string, error) {
+	return callList[string](ctx, c, "core.modules", nil)
+}
@@ -0,0 +1,336 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type DialogState int
+
+const (
+	DialogUnconfirmed DialogState = 1
+	DialogEarly       DialogState = 2
+	DialogConfirmedNA DialogState = 3
+	DialogConfirmed   DialogState = 4
+	DialogDeleted     DialogState = 5
+)
+
+func (s DialogState) String() string {
+	switch s {
+	case DialogUnconfirmed:
+		return "unconfirmed"
+	case DialogEarly:
+		return "early"
+	case DialogConfirmedNA:
+		return "confirmed_na"
+	case DialogConfirmed:
+		return "confirmed"
+	case DialogDeleted:
+		return "deleted"
+	}
+
+	return "unknown"
+}
+
+func (s DialogState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// DialogLeg is the caller or callee side of a dialog.
+type DialogLeg struct {
+	Tag      string `json:"tag"`
+	Contact  string `json:"contact"`
+	CSeq     string `json:"cseq"`
+	RouteSet string `json:"route_set"`
+	Socket   string `json:"socket"`
+}
+
+// DialogProfile is the membership of a dialog in a profile. Value is empty
+// for profiles without value.
+type DialogProfile struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// Dialog is a typed entry of dlg.list. HashEntry and HashID identify the
+// dialog for DialogEnd.
+type Dialog struct {
+	HashEntry uint32            `json:"h_entry"`
+	HashID    uint32            `json:"h_id"`
+	Ref       int               `json:"ref"`
+	CallID    string            `json:"call_id"`
+	FromURI   string            `json:"from_uri"`
+	ToURI     string            `json:"to_uri"`
+	State     DialogState       `json:"state"`
+	Start     time.Time         `json:"start"`
+	Init      time.Time         `json:"init"`
+	End       time.Time         `json:"end"`
+	Timeout   time.Time         `json:"timeout"`
+	Lifetime  time.Duration     `json:"lifetime"`
+	DFlags    int               `json:"dflags"`
+	SFlags    int               `json:"sflags"`
+	IFlags    int               `json:"iflags"`
+	Caller    DialogLeg         `json:"caller"`
+	Callee    DialogLeg         `json:"callee"`
+	Profiles  []DialogProfile   `json:"profiles,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Context   map[string]any    `json:"context,omitempty"`
+}
+
+// DialogStats is the result of dlg.stats_active.
+type DialogStats struct {
+	Starting   int `json:"starting"`
+	Connecting int `json:"connecting"`
+	Answering  int `json:"answering"`
+	Ongoing    int `json:"ongoing"`
+	All        int `json:"all"`
+}
+
+type dialogJson struct {
+	HashEntry json.Number     `json:"h_entry"`
+	HashID    json.Number     `json:"h_id"`
+	Ref       json.Number     `json:"ref"`
+	CallID    string          `json:"call-id"`
+	FromURI   string          `json:"from_uri"`
+	ToURI     string          `json:"to_uri"`
+	State     json.Number     `json:"state"`
+	StartTs   json.Number     `json:"start_ts"`
+	InitTs    json.Number     `json:"init_ts"`
+	EndTs     json.Number     `json:"end_ts"`
+	Timeout   json.Number     `json:"timeout"`
+	Lifetime  json.Number     `json:"lifetime"`
+	DFlags    json.Number     `json:"dflags"`
+	SFlags    json.Number     `json:"sflags"`
+	IFlags    json.Number     `json:"iflags"`
+	Caller    dialogLegJson   `json:"caller"`
+	Callee    dialogLegJson   `json:"callee"`
+	Profiles  json.RawMessage `json:"profiles"`
+	Variables json.RawMessage `json:"variables"`
+	Context   map[string]any  `json:"context"`
+}
+
+type dialogLegJson struct {
+	Tag      string `json:"tag"`
+	Contact  string `json:"contact"`
+	CSeq     any    `json:"cseq"`
+	RouteSet string `json:"route_set"`
+	Socket   string `json:"socket"`
+}
+
+func (l dialogLegJson) leg() DialogLeg {
+	leg := DialogLeg{
+		Tag:      l.Tag,
+		Contact:  l.Contact,
+		RouteSet: l.RouteSet,
+		Socket:   l.Socket,
+	}
+
+	if l.CSeq != nil {
+		leg.CSeq = fmt.Sprint(l.CSeq)
+	}
+
+	return leg
+}
+
+func (d dialogJson) dialog() Dialog {
+	dialog := Dialog{
+		HashEntry: uint32(numberInt(d.HashEntry)),
+		HashID:    uint32(numberInt(d.HashID)),
+		Ref:       int(numberInt(d.Ref)),
+		CallID:    d.CallID,
+		FromURI:   d.FromURI,
+		ToURI:     d.ToURI,
+		State:     DialogState(numberInt(d.State)),
+		Start:     unixTime(numberInt(d.StartTs)),
+		Init:      unixTime(numberInt(d.InitTs)),
+		End:       unixTime(numberInt(d.EndTs)),
+		Timeout:   unixTime(numberInt(d.Timeout)),
+		Lifetime:  time.Duration(numberInt(d.Lifetime)) * time.Second,
+		DFlags:    int(numberInt(d.DFlags)),
+		SFlags:    int(numberInt(d.SFlags)),
+		IFlags:    int(numberInt(d.IFlags)),
+		Caller:    d.Caller.leg(),
+		Callee:    d.Callee.leg(),
+		Context:   d.Context,
+	}
+
+	for _, pair := range namedValues(d.Profiles) {
+		dialog.Profiles = append(dialog.Profiles, DialogProfile{Name: pair[0], Value: pair[1]})
+	}
+
+	for _, pair := range namedValues(d.Variables) {
+		if dialog.Variables == nil {
+			dialog.Variables = map[string]string{}
+		}
+
+		dialog.Variables[pair[0]] = pair[1]
+	}
+
+	return dialog
+}
+
+// namedValues flattens the name/value lists of dlg.list, which come as
+// an array of single member structs or as one struct.
+func namedValues(raw json.RawMessage) [][2]string {
+	var list []map[string]any
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var single map[string]any
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil
+		}
+
+		list = []map[string]any{single}
+	}
+
+	var pairs [][2]string
+	for _, members := range list {
+		names := make([]string, 0, len(members))
+		for name := range members {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			value := ""
+			if members[name] != nil {
+				value = fmt.Sprint(members[name])
+			}
+
+			pairs = append(pairs, [2]string{name, value})
+		}
+	}
+
+	return pairs
+}
+
+func (c *Client) callDialogs(ctx context.Context, method string, params any) ([]Dialog, error) {
+	list, err := callList[dialogJson](ctx, c, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	dialogs := make([]Dialog, 0, len(list))
+	for _, d := range list {
+		dialogs = append(dialogs, d.dialog())
+	}
+
+	return dialogs, nil
+}
+
+// Dialogs returns all dialogs (dlg.list).
+func (c *Client) Dialogs(ctx context.Context) ([]Dialog, error) {
+	return c.callDialogs(ctx, "dlg.list", nil)
+}
+
+// DialogsWithContext returns all dialogs with their context (dlg.list_ctx).
+func (c *Client) DialogsWithContext(ctx context.Context) ([]Dialog, error) {
+	return c.callDialogs(ctx, "dlg.list_ctx", nil)
+}
+
+// DialogLookup returns the dialogs with callid, and fromtag if not empty
+// (dlg.dlg_list).
+func (c *Client) DialogLookup(ctx context.Context, callid string, fromtag string) ([]Dialog, error) {
+	params := []any{callid}
+	if fromtag != "" {
+		params = append(params, fromtag)
+	}
+
+	return c.callDialogs(ctx, "dlg.dlg_list", params)
+}
+
+// DialogEnd tears down a dialog by its hash entry and id, sending BYE to
+// both sides. extraHeaders is added to the BYEs when not empty.
+func (c *Client) DialogEnd(ctx context.Context, hashEntry uint32, hashID uint32, extraHeaders string) error {
+	params := []any{hashEntry, hashID}
+	if extraHeaders != "" {
+		params = append(params, extraHeaders)
+	}
+
+	return c.Call(ctx, "dlg.end_dlg", params, nil)
+}
+
+// DialogTerminate tears down a dialog by call-id and tags.
+func (c *Client) DialogTerminate(ctx context.Context, callid string, fromtag string, totag string) error {
+	return c.Call(ctx, "dlg.terminate_dlg", []any{callid, fromtag, totag}, nil)
+}
+
+// DialogProfileSize returns the number of dialogs in a profile, only those
+// with value if not empty.
+func (c *Client) DialogProfileSize(ctx context.Context, profile string, value string) (int64, error) {
+	params := []any{profile}
+	if value != "" {
+		params = append(params, value)
+	}
+
+	return c.callCount(ctx, "dlg.profile_get_size", "count", params...)
+}
+
+// DialogProfileList returns the dialogs in a profile, only those with value
+// if not empty.
+func (c *Client) DialogProfileList(ctx context.Context, profile string, value string) ([]Dialog, error) {
+	params := []any{profile}
+	if value != "" {
+		params = append(params, value)
+	}
+
+	return c.callDialogs(ctx, "dlg.profile_list", params)
+}
+
+func (c *Client) DialogStatsActive(ctx context.Context) (DialogStats, error) {
+	var stats DialogStats
+	err := c.Call(ctx, "dlg.stats_active", nil, &stats)
+	return stats, err
+}
+
+// Duration returns how long a confirmed dialog has been up.
+func (d Dialog) Duration() time.Duration {
+	if d.Start.IsZero() {
+		return 0
+	}
+
+	if !d.End.IsZero() {
+		return d.End.Sub(d.Start)
+	}
+
+	return time.Since(d.Start)
+}
+
+// InProfile reports whether the dialog is in profile, with value if not
+// empty.
+func (d Dialog) InProfile(profile string, value string) bool {
+	for _, p := range d.Profiles {
+		if p.Name == profile && (value == "" || p.Value == value) {
+			return true
+		}
+	}
+
+	return false
+}
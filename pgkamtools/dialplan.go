@@ -0,0 +1,369 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dialplan match_op values.
+const (
+	DialplanMatchEqual   = 0
+	DialplanMatchRegex   = 1
+	DialplanMatchFnmatch = 2
+)
+
+// ErrDialplanNoMatch is returned by Dialplan.Translate when no rule matches.
+var ErrDialplanNoMatch = errors.New("no dialplan rule matched")
+
+// DialplanRule is one rule of dialplan.dump, or a row of the dialplan table.
+type DialplanRule struct {
+	DPID     int    `json:"dpid"`
+	Priority int    `json:"pr"`
+	MatchOp  int    `json:"match_op"`
+	MatchExp string `json:"match_exp"`
+	MatchLen int    `json:"match_len"`
+	SubstExp string `json:"subst_exp"`
+	ReplExp  string `json:"repl_exp"`
+	Attrs    string `json:"attrs"`
+}
+
+// DialplanTranslation is the result of a translation. Rule is only set by
+// the offline Dialplan.
+type DialplanTranslation struct {
+	Output string        `json:"output"`
+	Attrs  string        `json:"attrs"`
+	Rule   *DialplanRule `json:"rule,omitempty"`
+}
+
+type dialplanRuleJson struct {
+	Prio     json.Number `json:"PRIO"`
+	MatchOp  json.Number `json:"MATCHOP"`
+	MatchExp string      `json:"MATCHEXP"`
+	MatchLen json.Number `json:"MATCHLEN"`
+	SubstExp string      `json:"SUBSTEXP"`
+	ReplExp  string      `json:"REPLEXP"`
+	Attrs    string      `json:"ATTRS"`
+}
+
+type dialplanDumpJson struct {
+	DPID    json.Number       `json:"DPID"`
+	Entries []json.RawMessage `json:"ENTRIES"`
+}
+
+// DialplanDump returns the rules of dpid (dialplan.dump).
+func (c *Client) DialplanDump(ctx context.Context, dpid int) ([]DialplanRule, error) {
+	dumps, err := callList[dialplanDumpJson](ctx, c, "dialplan.dump", []any{dpid})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []DialplanRule
+	for _, dump := range dumps {
+		for _, raw := range dump.Entries {
+			entries, err := decodeRepeated[dialplanRuleJson](raw, "ENTRY")
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				rules = append(rules, DialplanRule{
+					DPID:     dpid,
+					Priority: int(numberInt(e.Prio)),
+					MatchOp:  int(numberInt(e.MatchOp)),
+					MatchExp: e.MatchExp,
+					MatchLen: int(numberInt(e.MatchLen)),
+					SubstExp: e.SubstExp,
+					ReplExp:  e.ReplExp,
+					Attrs:    e.Attrs,
+				})
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+func (c *Client) DialplanReload(ctx context.Context) error {
+	return c.Call(ctx, "dialplan.reload", nil, nil)
+}
+
+// DialplanTranslate translates input with the rules of dpid in Kamailio
+// (dialplan.translate).
+func (c *Client) DialplanTranslate(ctx context.Context, dpid int, input string) (DialplanTranslation, error) {
+	var result struct {
+		Output     string `json:"Output"`
+		Attributes string `json:"Attributes"`
+	}
+
+	err := c.Call(ctx, "dialplan.translate", []any{dpid, input}, &result)
+	return DialplanTranslation{Output: result.Output, Attrs: result.Attributes}, err
+}
+
+// Dialplan evaluates dialplan rules offline, the way the dialplan module
+// does: the rules of a dpid whose match_len equals the input length are tried
+// first, then those with match_len 0, each group by priority (lowest first,
+// then in the order given). The first matching rule translates the input.
+// Regular expressions use Go's regexp syntax, so pcre only features fail in
+// NewDialplan, and pseudo variables in repl_exp are left as they are.
+type Dialplan struct {
+	// rules by dpid and match_len
+	rules map[int]map[int][]dialplanCompiled
+}
+
+type dialplanCompiled struct {
+	rule  DialplanRule
+	match *regexp.Regexp
+	subst *regexp.Regexp
+}
+
+// DialplanCase is an expected translation for Dialplan.Check. NoMatch
+// expects no rule to match.
+type DialplanCase struct {
+	DPID    int    `json:"dpid"`
+	Input   string `json:"input"`
+	Output  string `json:"output"`
+	Attrs   string `json:"attrs,omitempty"`
+	NoMatch bool   `json:"no_match,omitempty"`
+}
+
+// DialplanCaseFailure is a case whose translation differs.
+type DialplanCaseFailure struct {
+	Case   DialplanCase        `json:"case"`
+	Result DialplanTranslation `json:"result"`
+	Err    error               `json:"-"`
+}
+
+func (f DialplanCaseFailure) String() string {
+	if f.Err != nil {
+		return fmt.Sprintf("dpid %d %q: %v", f.Case.DPID, f.Case.Input, f.Err)
+	}
+
+	if f.Case.NoMatch {
+		return fmt.Sprintf("dpid %d %q: expected no match, got %q", f.Case.DPID, f.Case.Input, f.Result.Output)
+	}
+
+	return fmt.Sprintf("dpid %d %q: expected %q, got %q", f.Case.DPID, f.Case.Input, f.Case.Output, f.Result.Output)
+}
+
+// NewDialplan compiles rules for offline translation.
+func NewDialplan(rules []DialplanRule) (*Dialplan, error) {
+	d := &Dialplan{rules: map[int]map[int][]dialplanCompiled{}}
+	for _, rule := range rules {
+		compiled := dialplanCompiled{rule: rule}
+
+		var err error
+		switch rule.MatchOp {
+		case DialplanMatchEqual:
+		case DialplanMatchRegex:
+			compiled.match, err = regexp.Compile(rule.MatchExp)
+		case DialplanMatchFnmatch:
+			compiled.match, err = regexp.Compile(fnmatchRegexp(rule.MatchExp))
+		default:
+			err = fmt.Errorf("unknown match_op %d", rule.MatchOp)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("dialplan %d rule %q: %w", rule.DPID, rule.MatchExp, err)
+		}
+
+		if rule.SubstExp != "" {
+			compiled.subst, err = regexp.Compile(rule.SubstExp)
+			if err != nil {
+				return nil, fmt.Errorf("dialplan %d rule %q subst_exp: %w", rule.DPID, rule.MatchExp, err)
+			}
+		}
+
+		if d.rules[rule.DPID] == nil {
+			d.rules[rule.DPID] = map[int][]dialplanCompiled{}
+		}
+
+		d.rules[rule.DPID][rule.MatchLen] = append(d.rules[rule.DPID][rule.MatchLen], compiled)
+	}
+
+	for _, byLen := range d.rules {
+		for _, group := range byLen {
+			group := group
+			sort.SliceStable(group, func(i, j int) bool {
+				return group[i].rule.Priority < group[j].rule.Priority
+			})
+		}
+	}
+
+	return d, nil
+}
+
+// Translate translates input with the rules of dpid. It returns
+// ErrDialplanNoMatch when no rule matches.
+func (d *Dialplan) Translate(dpid int, input string) (DialplanTranslation, error) {
+	var groups [][]dialplanCompiled
+	if len(input) != 0 {
+		groups = append(groups, d.rules[dpid][len(input)])
+	}
+
+	groups = append(groups, d.rules[dpid][0])
+	for _, group := range groups {
+		for i := range group {
+			compiled := &group[i]
+			if !compiled.matches(input) {
+				continue
+			}
+
+			output, err := compiled.translate(input)
+			if err != nil {
+				return DialplanTranslation{}, err
+			}
+
+			rule := compiled.rule
+			return DialplanTranslation{Output: output, Attrs: rule.Attrs, Rule: &rule}, nil
+		}
+	}
+
+	return DialplanTranslation{}, ErrDialplanNoMatch
+}
+
+// Check translates every case and returns those that differ.
+func (d *Dialplan) Check(cases []DialplanCase) []DialplanCaseFailure {
+	var failures []DialplanCaseFailure
+	for _, tc := range cases {
+		result, err := d.Translate(tc.DPID, tc.Input)
+		switch {
+		case tc.NoMatch && errors.Is(err, ErrDialplanNoMatch):
+			continue
+		case tc.NoMatch && err == nil:
+			failures = append(failures, DialplanCaseFailure{Case: tc, Result: result})
+		case err != nil:
+			failures = append(failures, DialplanCaseFailure{Case: tc, Err: err})
+		case result.Output != tc.Output || (tc.Attrs != "" && result.Attrs != tc.Attrs):
+			failures = append(failures, DialplanCaseFailure{Case: tc, Result: result})
+		}
+	}
+
+	return failures
+}
+
+func (c *dialplanCompiled) matches(input string) bool {
+	if c.rule.MatchOp == DialplanMatchEqual {
+		return c.rule.MatchExp == input
+	}
+
+	return c.match.MatchString(input)
+}
+
+// translate builds the output from repl_exp alone, like the dialplan module:
+// \0 to \9 are the groups matched by subst_exp, the rest of the input is
+// dropped. repl_exp without back-references is copied as is. Without
+// subst_exp the output is repl_exp, or the input when repl_exp is empty too.
+func (c *dialplanCompiled) translate(input string) (string, error) {
+	if c.subst == nil {
+		if c.rule.ReplExp == "" {
+			return input, nil
+		}
+
+		return c.rule.ReplExp, nil
+	}
+
+	groups := c.subst.FindStringSubmatchIndex(input)
+	if groups == nil {
+		return "", fmt.Errorf("dialplan %d rule %q: subst_exp %q doesn't match %q", c.rule.DPID, c.rule.MatchExp, c.rule.SubstExp, input)
+	}
+
+	repl := c.rule.ReplExp
+	var out strings.Builder
+	for i := 0; i < len(repl); i++ {
+		if repl[i] == '\\' && i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9' {
+			group := int(repl[i+1] - '0')
+			if 2*group+1 < len(groups) && groups[2*group] >= 0 {
+				out.WriteString(input[groups[2*group]:groups[2*group+1]])
+			}
+
+			i++
+			continue
+		}
+
+		out.WriteByte(repl[i])
+	}
+
+	return out.String(), nil
+}
+
+// fnmatchRegexp converts an fnmatch pattern (flags 0) to an anchored
+// regular expression.
+func fnmatchRegexp(pattern string) string {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			} else {
+				re.WriteString(`\\`)
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+
+			// a ] right after [ or [! is part of the class
+			if end == 0 || (end == 1 && pattern[i+1] == '!') {
+				next := strings.IndexByte(pattern[i+end+2:], ']')
+				if next < 0 {
+					re.WriteString(`\[`)
+					continue
+				}
+
+				end += next + 1
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	re.WriteString("$")
+	return re.String()
+}
@@ -0,0 +1,142 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDialplanTranslate(t *testing.T) {
+	rules := []DialplanRule{
+		// dpid 1: match_op
+		{DPID: 1, Priority: 1, MatchOp: DialplanMatchEqual, MatchExp: "911", ReplExp: "sos"},
+		{DPID: 1, Priority: 2, MatchOp: DialplanMatchFnmatch, MatchExp: "00[1-9]*", SubstExp: "^00(.*)$", ReplExp: `+\1`},
+		{DPID: 1, Priority: 3, MatchOp: DialplanMatchRegex, MatchExp: "^0[1-9]", SubstExp: "^0", ReplExp: "+49"},
+
+		// dpid 2: match_len rules before the others, whatever the priority
+		{DPID: 2, Priority: 1, MatchOp: DialplanMatchRegex, MatchExp: ".*", ReplExp: "any", Attrs: "any"},
+		{DPID: 2, Priority: 5, MatchOp: DialplanMatchRegex, MatchExp: "^[0-9]+$", MatchLen: 4, ReplExp: "short", Attrs: "short"},
+		{DPID: 2, Priority: 3, MatchOp: DialplanMatchRegex, MatchExp: "^9", MatchLen: 4, ReplExp: "nine", Attrs: "nine"},
+
+		// dpid 3: priority, then order
+		{DPID: 3, Priority: 10, MatchOp: DialplanMatchRegex, MatchExp: "^1", ReplExp: "ten"},
+		{DPID: 3, Priority: 2, MatchOp: DialplanMatchRegex, MatchExp: "^1", ReplExp: "two"},
+		{DPID: 3, Priority: 2, MatchOp: DialplanMatchRegex, MatchExp: "^1", ReplExp: "two again"},
+		{DPID: 3, Priority: 10, MatchOp: DialplanMatchRegex, MatchExp: "^2", ReplExp: "ten"},
+
+		// dpid 4: back-references
+		{DPID: 4, MatchOp: DialplanMatchRegex, MatchExp: "^sip:", SubstExp: `^sip:([^@]+)@(.*)$`, ReplExp: `\2/\1/\0`},
+		{DPID: 4, MatchOp: DialplanMatchRegex, MatchExp: "^x", SubstExp: `^x(a)?(b)$`, ReplExp: `[\1\2\9]`},
+		{DPID: 4, MatchOp: DialplanMatchRegex, MatchExp: "^keep", Attrs: "kept"},
+	}
+
+	dp, err := NewDialplan(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dpid   int
+		input  string
+		output string
+		attrs  string
+		miss   bool
+	}{
+		{dpid: 1, input: "911", output: "sos"},
+		{dpid: 1, input: "9110", miss: true},
+		{dpid: 1, input: "0044207", output: "+44207"},
+		{dpid: 1, input: "0301234", output: "+49"},
+		{dpid: 1, input: "0001", miss: true},
+		{dpid: 1, input: "123", miss: true},
+
+		{dpid: 2, input: "1234", output: "short", attrs: "short"},
+		{dpid: 2, input: "9234", output: "nine", attrs: "nine"},
+		{dpid: 2, input: "12a4", output: "any", attrs: "any"},
+		{dpid: 2, input: "12345", output: "any", attrs: "any"},
+
+		{dpid: 3, input: "1", output: "two"},
+		{dpid: 3, input: "2", output: "ten"},
+
+		{dpid: 4, input: "sip:alice@example.com", output: "example.com/alice/sip:alice@example.com"},
+		{dpid: 4, input: "xb", output: "[b]"},
+		{dpid: 4, input: "keep me", output: "keep me", attrs: "kept"},
+
+		{dpid: 5, input: "1", miss: true},
+	}
+
+	for _, tt := range tests {
+		result, err := dp.Translate(tt.dpid, tt.input)
+		if tt.miss {
+			if !errors.Is(err, ErrDialplanNoMatch) {
+				t.Errorf("dpid %d %q: got %q, %v, want no match", tt.dpid, tt.input, result.Output, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("dpid %d %q: %v", tt.dpid, tt.input, err)
+			continue
+		}
+
+		if result.Output != tt.output || result.Attrs != tt.attrs {
+			t.Errorf("dpid %d %q: got %q (attrs %q), want %q (attrs %q)", tt.dpid, tt.input, result.Output, result.Attrs, tt.output, tt.attrs)
+		}
+	}
+}
+
+func TestDialplanCheck(t *testing.T) {
+	dp, err := NewDialplan([]DialplanRule{
+		{DPID: 1, MatchOp: DialplanMatchRegex, MatchExp: "^0", SubstExp: "^0(.*)$", ReplExp: `+49\1`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := dp.Check([]DialplanCase{
+		{DPID: 1, Input: "0301234", Output: "+49301234"},
+		{DPID: 1, Input: "0301234", Output: "0301234"},
+		{DPID: 1, Input: "1234", NoMatch: true},
+		{DPID: 1, Input: "0", NoMatch: true},
+	})
+
+	if len(failures) != 2 || failures[0].Case.Output != "0301234" || failures[1].Case.Input != "0" {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+}
+
+func TestNewDialplanInvalid(t *testing.T) {
+	for _, rule := range []DialplanRule{
+		{MatchOp: DialplanMatchRegex, MatchExp: "(?<=a)b"},
+		{MatchOp: DialplanMatchRegex, MatchExp: "a", SubstExp: "("},
+		{MatchOp: 7, MatchExp: "a"},
+	} {
+		if _, err := NewDialplan([]DialplanRule{rule}); err == nil {
+			t.Errorf("rule %+v: expected an error", rule)
+		}
+	}
+}
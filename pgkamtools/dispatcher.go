@@ -0,0 +1,387 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// DispatcherState is the state part of the dispatcher flags (first letter).
+type DispatcherState int
+
+const (
+	DispatcherActive DispatcherState = iota
+	DispatcherInactive
+	DispatcherDisabled
+	DispatcherTrying
+)
+
+func (s DispatcherState) String() string {
+	switch s {
+	case DispatcherActive:
+		return "active"
+	case DispatcherInactive:
+		return "inactive"
+	case DispatcherDisabled:
+		return "disabled"
+	case DispatcherTrying:
+		return "trying"
+	}
+
+	return "unknown"
+}
+
+func (s DispatcherState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Flag returns the letter used by dispatcher.set_state for s.
+func (s DispatcherState) Flag() string {
+	switch s {
+	case DispatcherInactive:
+		return "i"
+	case DispatcherDisabled:
+		return "d"
+	case DispatcherTrying:
+		return "t"
+	}
+
+	return "a"
+}
+
+type DispatcherSet struct {
+	ID           int                     `json:"id"`
+	Destinations []DispatcherDestination `json:"destinations"`
+}
+
+type DispatcherDestination struct {
+	Group    int               `json:"group"`
+	URI      string            `json:"uri"`
+	Flags    string            `json:"flags"`
+	State    DispatcherState   `json:"state"`
+	Probing  bool              `json:"probing"`
+	Priority int               `json:"priority"`
+	Attrs    DispatcherAttrs   `json:"attrs"`
+	Latency  DispatcherLatency `json:"latency"`
+	Socket   string            `json:"socket,omitempty"`
+}
+
+// DispatcherAttrs are the parsed destination attributes. Body holds the raw
+// attribute string.
+type DispatcherAttrs struct {
+	Body     string `json:"BODY"`
+	DUID     string `json:"DUID"`
+	MaxLoad  int    `json:"MAXLOAD"`
+	Weight   int    `json:"WEIGHT"`
+	RWeight  int    `json:"RWEIGHT"`
+	Socket   string `json:"SOCKET"`
+	SockName string `json:"SOCKNAME"`
+	OBProxy  string `json:"OBPROXY"`
+}
+
+// older kamailio versions print the attributes as a plain string.
+func (a *DispatcherAttrs) UnmarshalJSON(data []byte) error {
+	var body string
+	if err := json.Unmarshal(data, &body); err == nil {
+		*a = DispatcherAttrs{Body: body}
+		return nil
+	}
+
+	type attrs DispatcherAttrs
+	return json.Unmarshal(data, (*attrs)(a))
+}
+
+// DispatcherLatency is only filled when latency stats are enabled
+// (ds_ping_latency_stats).
+type DispatcherLatency struct {
+	Avg     float64 `json:"AVG"`
+	Std     float64 `json:"STD"`
+	Est     float64 `json:"EST"`
+	Max     float64 `json:"MAX"`
+	Timeout int     `json:"TIMEOUT"`
+}
+
+type dispatcherListResult struct {
+	NRSets  int `json:"NRSETS"`
+	Records []struct {
+		Set struct {
+			ID      int `json:"ID"`
+			Targets []struct {
+				Dest struct {
+					URI      string            `json:"URI"`
+					Flags    string            `json:"FLAGS"`
+					Priority int               `json:"PRIORITY"`
+					Attrs    DispatcherAttrs   `json:"ATTRS"`
+					Latency  DispatcherLatency `json:"LATENCY"`
+				} `json:"DEST"`
+			} `json:"TARGETS"`
+		} `json:"SET"`
+	} `json:"RECORDS"`
+}
+
+// DispatcherAddOptions are the optional fields of dispatcher.add.
+type DispatcherAddOptions struct {
+	Flags    int
+	Priority int
+	Attrs    string
+}
+
+type DispatcherHashResult struct {
+	HashID uint32 `json:"hashid"`
+	Slot   int    `json:"slot"`
+}
+
+// DispatcherSets is the typed result of dispatcher.list.
+type DispatcherSets []DispatcherSet
+
+func (c *Client) DispatcherAdd(ctx context.Context, groupval string, addressval string) (string, error) {
+	return c.CallRaw(ctx, "dispatcher.add", []any{groupval, addressval})
+}
+
+func (c *Client) DispatcherList(ctx context.Context) (string, error) {
+	return c.CallRaw(ctx, "dispatcher.list", nil)
+}
+
+func (c *Client) DispatcherListSimple(ctx context.Context) (string, error) {
+	results, err := c.DispatcherList(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	resultJson := gjson.Get(results, "result.RECORDS.#[@flatten].SET.TARGETS.#.DEST.URI")
+	var jsonResult string
+	for _, nodeValue := range resultJson.Array() {
+		jsonResult, _ = sjson.Set(jsonResult, "nodes.-1", nodeValue.Str)
+	}
+
+	return jsonResult, nil
+}
+
+func (c *Client) DispatcherListByGroup(ctx context.Context) (string, error) {
+	results, err := c.DispatcherList(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	resultJson := gjson.Get(results, "result.RECORDS.#.SET.{id:ID,nodes:TARGETS.#.{uri:DEST.URI,flags:DEST.FLAGS,priority:DEST.PRIORITY,latency:DEST.LATENCY.AVG}}")
+	return resultJson.String(), nil
+}
+
+func (c *Client) DispatcherRemove(ctx context.Context, groupval string, addressval string) (string, error) {
+	return c.CallRaw(ctx, "dispatcher.remove", []any{groupval, addressval})
+}
+
+// Dispatchers returns dispatcher.list as typed sets.
+func (c *Client) Dispatchers(ctx context.Context) (DispatcherSets, error) {
+	var list dispatcherListResult
+	if err := c.Call(ctx, "dispatcher.list", nil, &list); err != nil {
+		return nil, err
+	}
+
+	sets := make(DispatcherSets, 0, len(list.Records))
+	for _, record := range list.Records {
+		set := DispatcherSet{ID: record.Set.ID}
+		for _, target := range record.Set.Targets {
+			dest := DispatcherDestination{
+				Group:    record.Set.ID,
+				URI:      target.Dest.URI,
+				Flags:    target.Dest.Flags,
+				Priority: target.Dest.Priority,
+				Attrs:    target.Dest.Attrs,
+				Latency:  target.Dest.Latency,
+				Socket:   target.Dest.Attrs.Socket,
+			}
+
+			if dest.Socket == "" {
+				dest.Socket = target.Dest.Attrs.SockName
+			}
+
+			dest.State, dest.Probing = ParseDispatcherFlags(dest.Flags)
+			set.Destinations = append(set.Destinations, dest)
+		}
+
+		sets = append(sets, set)
+	}
+
+	return sets, nil
+}
+
+// ParseDispatcherFlags decodes the FLAGS of dispatcher.list (ie "AP", "IX").
+func ParseDispatcherFlags(flags string) (DispatcherState, bool) {
+	flags = strings.ToUpper(flags)
+	state := DispatcherActive
+	switch {
+	case strings.HasPrefix(flags, "I"):
+		state = DispatcherInactive
+	case strings.HasPrefix(flags, "D"):
+		state = DispatcherDisabled
+	case strings.HasPrefix(flags, "T"):
+		state = DispatcherTrying
+	}
+
+	return state, strings.Contains(flags, "P")
+}
+
+// Group returns the set with id.
+func (s DispatcherSets) Group(id int) (DispatcherSet, bool) {
+	for _, set := range s {
+		if set.ID == id {
+			return set, true
+		}
+	}
+
+	return DispatcherSet{}, false
+}
+
+// Destinations returns the destinations of all sets.
+func (s DispatcherSets) Destinations() []DispatcherDestination {
+	var dests []DispatcherDestination
+	for _, set := range s {
+		dests = append(dests, set.Destinations...)
+	}
+
+	return dests
+}
+
+// Filter returns the destinations for which keep returns true.
+func (s DispatcherSets) Filter(keep func(DispatcherDestination) bool) []DispatcherDestination {
+	var dests []DispatcherDestination
+	for _, set := range s {
+		for _, dest := range set.Destinations {
+			if keep(dest) {
+				dests = append(dests, dest)
+			}
+		}
+	}
+
+	return dests
+}
+
+// ByState returns the destinations in any of the given states.
+func (s DispatcherSets) ByState(states ...DispatcherState) []DispatcherDestination {
+	return s.Filter(func(dest DispatcherDestination) bool {
+		for _, state := range states {
+			if dest.State == state {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// Probing returns the destinations that are being probed.
+func (s DispatcherSets) Probing() []DispatcherDestination {
+	return s.Filter(func(dest DispatcherDestination) bool {
+		return dest.Probing
+	})
+}
+
+// DispatcherAddWith adds a destination with flags, priority and attributes
+// (ie "weight=50;duid=gw1").
+func (c *Client) DispatcherAddWith(ctx context.Context, group int, address string, opts DispatcherAddOptions) error {
+	return c.Call(ctx, "dispatcher.add", []any{group, address, opts.Flags, opts.Priority, opts.Attrs}, nil)
+}
+
+// DispatcherSetState sets the state of a destination. Use "all" as address
+// for every destination in the group.
+func (c *Client) DispatcherSetState(ctx context.Context, state DispatcherState, probing bool, group int, address string) error {
+	flag := state.Flag()
+	if probing {
+		flag += "p"
+	}
+
+	return c.Call(ctx, "dispatcher.set_state", []any{flag, group, address}, nil)
+}
+
+func (c *Client) DispatcherReload(ctx context.Context) error {
+	return c.Call(ctx, "dispatcher.reload", nil, nil)
+}
+
+// DispatcherPingActive returns the global ping active flag.
+func (c *Client) DispatcherPingActive(ctx context.Context) (bool, error) {
+	var result dispatcherPingState
+	if err := c.Call(ctx, "dispatcher.ping_active", nil, &result); err != nil {
+		return false, err
+	}
+
+	return result.active()
+}
+
+// DispatcherSetPingActive turns the keepalive pinging on or off for all
+// destinations and returns the new value.
+func (c *Client) DispatcherSetPingActive(ctx context.Context, active bool) (bool, error) {
+	value := 0
+	if active {
+		value = 1
+	}
+
+	var result dispatcherPingState
+	if err := c.Call(ctx, "dispatcher.ping_active", []any{value}, &result); err != nil {
+		return false, err
+	}
+
+	return result.active()
+}
+
+// dispatcherPingState is the reply of dispatcher.ping_active: PingState
+// when reading the flag, OldPingState and NewPingState when setting it.
+type dispatcherPingState struct {
+	PingState    *int `json:"PingState"`
+	OldPingState *int `json:"OldPingState"`
+	NewPingState *int `json:"NewPingState"`
+}
+
+func (s dispatcherPingState) active() (bool, error) {
+	if s.NewPingState != nil {
+		return *s.NewPingState != 0, nil
+	}
+
+	if s.PingState != nil {
+		return *s.PingState != 0, nil
+	}
+
+	return false, errors.New("unexpected dispatcher.ping_active result")
+}
+
+// DispatcherHash returns the hash kamailio computes for values, and the
+// slot for nslots.
+func (c *Client) DispatcherHash(ctx context.Context, nslots int, values ...string) (DispatcherHashResult, error) {
+	params := []any{nslots}
+	for _, value := range values {
+		params = append(params, value)
+	}
+
+	var result DispatcherHashResult
+	err := c.Call(ctx, "dispatcher.hash", params, &result)
+	return result, err
+}
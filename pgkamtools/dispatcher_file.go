@@ -0,0 +1,356 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// flag bits of the dispatcher.list file.
+const (
+	DispatcherFlagInactive = 1
+	DispatcherFlagTrying   = 2
+	DispatcherFlagDisabled = 4
+	DispatcherFlagProbing  = 8
+)
+
+// DispatcherFile is a parsed dispatcher.list file. Comments and blank lines
+// are kept so the file can be written back as it was.
+type DispatcherFile struct {
+	Lines []DispatcherFileLine
+}
+
+// DispatcherFileLine is either an entry or a comment/blank line (Text).
+type DispatcherFileLine = listFileLine[DispatcherEntry]
+
+// DispatcherEntry is one destination line:
+//
+//	setid destination [flags [priority [attrs]]]
+type DispatcherEntry struct {
+	SetID    int
+	URI      string
+	Flags    int
+	Priority int
+	Attrs    string
+}
+
+type DispatcherFileChange struct {
+	File DispatcherEntry
+	Live DispatcherDestination
+}
+
+// DispatcherFileDiff lists the differences between a file and the live
+// dispatcher.list. Flags are not compared, since live flags reflect the
+// probing state.
+type DispatcherFileDiff struct {
+	OnlyInFile []DispatcherEntry
+	OnlyLive   []DispatcherDestination
+	Changed    []DispatcherFileChange
+}
+
+// DispatcherPushOptions control PushDispatcherFile.
+type DispatcherPushOptions struct {
+	// Reload saves the file to Path and calls dispatcher.reload instead of
+	// adding and removing destinations one by one. Path must be the file
+	// Kamailio loads.
+	Reload bool
+	Path   string
+
+	// DryRun only returns the diff.
+	DryRun bool
+}
+
+func (d DispatcherFileDiff) Empty() bool {
+	return len(d.OnlyInFile) == 0 && len(d.OnlyLive) == 0 && len(d.Changed) == 0
+}
+
+func ParseDispatcherFile(r io.Reader) (*DispatcherFile, error) {
+	lines, err := parseListFile(r, "dispatcher file", parseDispatcherEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DispatcherFile{Lines: lines}, nil
+}
+
+func parseDispatcherEntry(text string) (DispatcherEntry, error) {
+	var entry DispatcherEntry
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return entry, errors.New("expected setid and destination")
+	}
+
+	var err error
+	entry.SetID, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return entry, errors.New("invalid setid " + fields[0])
+	}
+
+	entry.URI = fields[1]
+	if len(fields) > 2 {
+		entry.Flags, err = strconv.Atoi(fields[2])
+		if err != nil {
+			return entry, errors.New("invalid flags " + fields[2])
+		}
+	}
+
+	if len(fields) > 3 {
+		entry.Priority, err = strconv.Atoi(fields[3])
+		if err != nil {
+			return entry, errors.New("invalid priority " + fields[3])
+		}
+	}
+
+	if len(fields) > 4 {
+		entry.Attrs = strings.Join(fields[4:], " ")
+	}
+
+	return entry, nil
+}
+
+func ReadDispatcherFile(path string) (*DispatcherFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ParseDispatcherFile(f)
+}
+
+func (e DispatcherEntry) String() string {
+	line := strconv.Itoa(e.SetID) + " " + e.URI + " " + strconv.Itoa(e.Flags) + " " + strconv.Itoa(e.Priority)
+	if e.Attrs != "" {
+		line += " " + e.Attrs
+	}
+
+	return line
+}
+
+func (f *DispatcherFile) WriteTo(w io.Writer) (int64, error) {
+	return writeListFile(w, f.Lines)
+}
+
+// Save replaces the dispatcher.list file at path.
+func (f *DispatcherFile) Save(path string) error {
+	return saveFile(path, ".dispatcher.list.*", func(w io.Writer) error {
+		_, err := f.WriteTo(w)
+		return err
+	})
+}
+
+func (f *DispatcherFile) Entries() []DispatcherEntry {
+	return listFileEntries(f.Lines)
+}
+
+func dispatcherEntryKey(setid int, uri string) string {
+	return strconv.Itoa(setid) + " " + uri
+}
+
+// DiffDispatcherFile compares the file against live dispatcher.list output.
+func DiffDispatcherFile(f *DispatcherFile, live DispatcherSets) DispatcherFileDiff {
+	var diff DispatcherFileDiff
+	liveDests := map[string]DispatcherDestination{}
+	for _, dest := range live.Destinations() {
+		liveDests[dispatcherEntryKey(dest.Group, dest.URI)] = dest
+	}
+
+	for _, entry := range f.Entries() {
+		key := dispatcherEntryKey(entry.SetID, entry.URI)
+		dest, ok := liveDests[key]
+		if !ok {
+			diff.OnlyInFile = append(diff.OnlyInFile, entry)
+			continue
+		}
+
+		delete(liveDests, key)
+		if entry.Priority != dest.Priority || entry.Attrs != dest.Attrs.Body {
+			diff.Changed = append(diff.Changed, DispatcherFileChange{File: entry, Live: dest})
+		}
+	}
+
+	for _, dest := range live.Destinations() {
+		if _, ok := liveDests[dispatcherEntryKey(dest.Group, dest.URI)]; ok {
+			diff.OnlyLive = append(diff.OnlyLive, dest)
+		}
+	}
+
+	return diff
+}
+
+// DispatcherEntryFromLive converts a live destination to a file entry.
+func DispatcherEntryFromLive(dest DispatcherDestination) DispatcherEntry {
+	entry := DispatcherEntry{
+		SetID:    dest.Group,
+		URI:      dest.URI,
+		Priority: dest.Priority,
+		Attrs:    dest.Attrs.Body,
+	}
+
+	switch dest.State {
+	case DispatcherInactive:
+		entry.Flags |= DispatcherFlagInactive
+	case DispatcherDisabled:
+		entry.Flags |= DispatcherFlagDisabled
+	case DispatcherTrying:
+		entry.Flags |= DispatcherFlagTrying
+	}
+
+	if dest.Probing {
+		entry.Flags |= DispatcherFlagProbing
+	}
+
+	return entry
+}
+
+// ApplyLive updates the file to match live. Existing lines keep their
+// position, flags and comments; removed destinations are dropped and new
+// ones are appended.
+func (f *DispatcherFile) ApplyLive(live DispatcherSets) DispatcherFileDiff {
+	diff := DiffDispatcherFile(f, live)
+	removed := map[string]bool{}
+	for _, entry := range diff.OnlyInFile {
+		removed[dispatcherEntryKey(entry.SetID, entry.URI)] = true
+	}
+
+	changed := map[string]DispatcherDestination{}
+	for _, change := range diff.Changed {
+		changed[dispatcherEntryKey(change.File.SetID, change.File.URI)] = change.Live
+	}
+
+	lines := f.Lines[:0]
+	for _, line := range f.Lines {
+		if line.Entry != nil {
+			key := dispatcherEntryKey(line.Entry.SetID, line.Entry.URI)
+			if removed[key] {
+				continue
+			}
+
+			if dest, ok := changed[key]; ok {
+				entry := *line.Entry
+				entry.Priority = dest.Priority
+				entry.Attrs = dest.Attrs.Body
+				line.Entry = &entry
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	for _, dest := range diff.OnlyLive {
+		entry := DispatcherEntryFromLive(dest)
+		lines = append(lines, DispatcherFileLine{Entry: &entry})
+	}
+
+	f.Lines = lines
+	return diff
+}
+
+// SyncDispatcherFile writes the live dispatcher.list state to the file at
+// path, keeping its comments. A missing file is created.
+func (c *Client) SyncDispatcherFile(ctx context.Context, path string) (DispatcherFileDiff, error) {
+	live, err := c.Dispatchers(ctx)
+	if err != nil {
+		return DispatcherFileDiff{}, err
+	}
+
+	f, err := ReadDispatcherFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		f = &DispatcherFile{}
+	} else if err != nil {
+		return DispatcherFileDiff{}, err
+	}
+
+	diff := f.ApplyLive(live)
+	if diff.Empty() {
+		return diff, nil
+	}
+
+	return diff, f.Save(path)
+}
+
+// PushDispatcherFile makes the live state match the file, either with
+// dispatcher.add/dispatcher.remove or with dispatcher.reload.
+func (c *Client) PushDispatcherFile(ctx context.Context, f *DispatcherFile, opts DispatcherPushOptions) (DispatcherFileDiff, error) {
+	if opts.Reload && opts.Path == "" {
+		return DispatcherFileDiff{}, errors.New("dispatcher reload needs the path of the dispatcher.list file")
+	}
+
+	live, err := c.Dispatchers(ctx)
+	if err != nil {
+		return DispatcherFileDiff{}, err
+	}
+
+	diff := DiffDispatcherFile(f, live)
+	if opts.DryRun || diff.Empty() {
+		return diff, nil
+	}
+
+	if opts.Reload {
+		if err := f.Save(opts.Path); err != nil {
+			return diff, err
+		}
+
+		return diff, c.DispatcherReload(ctx)
+	}
+
+	for _, dest := range diff.OnlyLive {
+		if _, err := c.DispatcherRemove(ctx, strconv.Itoa(dest.Group), dest.URI); err != nil {
+			return diff, err
+		}
+	}
+
+	for _, change := range diff.Changed {
+		if _, err := c.DispatcherRemove(ctx, strconv.Itoa(change.File.SetID), change.File.URI); err != nil {
+			return diff, err
+		}
+
+		if err := c.dispatcherAddEntry(ctx, change.File); err != nil {
+			return diff, err
+		}
+	}
+
+	for _, entry := range diff.OnlyInFile {
+		if err := c.dispatcherAddEntry(ctx, entry); err != nil {
+			return diff, err
+		}
+	}
+
+	return diff, nil
+}
+
+func (c *Client) dispatcherAddEntry(ctx context.Context, entry DispatcherEntry) error {
+	return c.DispatcherAddWith(ctx, entry.SetID, entry.URI, DispatcherAddOptions{
+		Flags:    entry.Flags,
+		Priority: entry.Priority,
+		Attrs:    entry.Attrs,
+	})
+}
@@ -0,0 +1,238 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type DispatcherEventType int
+
+const (
+	DispatcherEventAdded DispatcherEventType = iota
+	DispatcherEventRemoved
+	DispatcherEventActive
+	DispatcherEventInactive
+	DispatcherEventDisabled
+	DispatcherEventTrying
+	DispatcherEventProbing
+	DispatcherEventLatencyHigh
+	DispatcherEventLatencyNormal
+)
+
+func (t DispatcherEventType) String() string {
+	switch t {
+	case DispatcherEventAdded:
+		return "added"
+	case DispatcherEventRemoved:
+		return "removed"
+	case DispatcherEventActive:
+		return "active"
+	case DispatcherEventInactive:
+		return "inactive"
+	case DispatcherEventDisabled:
+		return "disabled"
+	case DispatcherEventTrying:
+		return "trying"
+	case DispatcherEventProbing:
+		return "probing"
+	case DispatcherEventLatencyHigh:
+		return "latency_high"
+	case DispatcherEventLatencyNormal:
+		return "latency_normal"
+	}
+
+	return "unknown"
+}
+
+func (t DispatcherEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// DispatcherEvent describes a change of one destination between two polls.
+// Previous is nil for added destinations, Destination is the last known
+// value for removed ones.
+type DispatcherEvent struct {
+	Type        DispatcherEventType    `json:"type"`
+	Time        time.Time              `json:"time"`
+	Group       int                    `json:"group"`
+	URI         string                 `json:"uri"`
+	Destination DispatcherDestination  `json:"destination"`
+	Previous    *DispatcherDestination `json:"previous,omitempty"`
+}
+
+// DispatcherWatcher polls dispatcher.list and reports changes. Set the
+// fields before calling Run or Watch.
+type DispatcherWatcher struct {
+	client *Client
+
+	// Interval between polls, 30 seconds if zero.
+	Interval time.Duration
+
+	// LatencyThreshold enables latency events when the average latency
+	// crosses it. Zero disables them.
+	LatencyThreshold time.Duration
+
+	// EmitInitial reports every destination of the first poll as added.
+	EmitInitial bool
+
+	// OnEvent is called for each event by Run.
+	OnEvent func(DispatcherEvent)
+
+	// OnError is called when a poll fails. The watcher keeps polling.
+	OnError func(error)
+
+	last DispatcherSets
+	seen bool
+}
+
+func (c *Client) NewDispatcherWatcher(interval time.Duration) *DispatcherWatcher {
+	return &DispatcherWatcher{
+		client:   c,
+		Interval: interval,
+	}
+}
+
+// Run polls until ctx is done and calls OnEvent for every change.
+func (w *DispatcherWatcher) Run(ctx context.Context) error {
+	return pollLoop(ctx, w.interval(), w.Poll, w.OnEvent, w.OnError)
+}
+
+// Watch runs the watcher in a goroutine and delivers events on the returned
+// channel, which is closed when ctx is done. OnEvent is not used.
+func (w *DispatcherWatcher) Watch(ctx context.Context) <-chan DispatcherEvent {
+	return watchLoop(ctx, w.interval(), 16, w.Poll, w.OnError)
+}
+
+func (w *DispatcherWatcher) interval() time.Duration {
+	if w.Interval <= 0 {
+		return 30 * time.Second
+	}
+
+	return w.Interval
+}
+
+// Poll fetches dispatcher.list once and returns the changes since the
+// previous poll.
+func (w *DispatcherWatcher) Poll(ctx context.Context) ([]DispatcherEvent, error) {
+	sets, err := w.client.Dispatchers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []DispatcherEvent
+	if w.seen || w.EmitInitial {
+		events = DiffDispatchers(w.last, sets, w.LatencyThreshold)
+	}
+
+	w.last = sets
+	w.seen = true
+	return events, nil
+}
+
+func dispatcherKey(dest DispatcherDestination) string {
+	return strconv.Itoa(dest.Group) + " " + dest.URI
+}
+
+// DiffDispatchers compares two dispatcher.list snapshots. latencyThreshold
+// of zero skips latency events.
+func DiffDispatchers(previous DispatcherSets, current DispatcherSets, latencyThreshold time.Duration) []DispatcherEvent {
+	now := time.Now()
+	before := map[string]DispatcherDestination{}
+	for _, dest := range previous.Destinations() {
+		before[dispatcherKey(dest)] = dest
+	}
+
+	var events []DispatcherEvent
+	event := func(eventType DispatcherEventType, dest DispatcherDestination, prev *DispatcherDestination) {
+		events = append(events, DispatcherEvent{
+			Type:        eventType,
+			Time:        now,
+			Group:       dest.Group,
+			URI:         dest.URI,
+			Destination: dest,
+			Previous:    prev,
+		})
+	}
+
+	threshold := float64(latencyThreshold) / float64(time.Millisecond)
+	for _, dest := range current.Destinations() {
+		key := dispatcherKey(dest)
+		prev, ok := before[key]
+		if !ok {
+			event(DispatcherEventAdded, dest, nil)
+			continue
+		}
+
+		delete(before, key)
+		if dest.State != prev.State {
+			event(stateEventType(dest.State), dest, &prev)
+		}
+
+		if dest.Probing && !prev.Probing {
+			event(DispatcherEventProbing, dest, &prev)
+		}
+
+		if latencyThreshold > 0 {
+			if dest.Latency.Avg >= threshold && prev.Latency.Avg < threshold {
+				event(DispatcherEventLatencyHigh, dest, &prev)
+			} else if dest.Latency.Avg < threshold && prev.Latency.Avg >= threshold {
+				event(DispatcherEventLatencyNormal, dest, &prev)
+			}
+		}
+	}
+
+	var removed []DispatcherDestination
+	for _, dest := range before {
+		removed = append(removed, dest)
+	}
+
+	sort.Slice(removed, func(i, j int) bool {
+		return dispatcherKey(removed[i]) < dispatcherKey(removed[j])
+	})
+
+	for _, dest := range removed {
+		event(DispatcherEventRemoved, dest, nil)
+	}
+
+	return events
+}
+
+func stateEventType(state DispatcherState) DispatcherEventType {
+	switch state {
+	case DispatcherInactive:
+		return DispatcherEventInactive
+	case DispatcherDisabled:
+		return DispatcherEventDisabled
+	case DispatcherTrying:
+		return DispatcherEventTrying
+	}
+
+	return DispatcherEventActive
+}
@@ -0,0 +1,124 @@
+//go:build !windows
+
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/tidwall/sjson"
+)
+
+// fifoTransport talks to the jsonrpcs fifo (transport 2). The request names
+// a reply fifo, which Kamailio opens inside its fifo_reply_dir.
+type fifoTransport struct {
+	path     string
+	replyDir string
+	timeout  time.Duration
+}
+
+func (t *fifoTransport) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	if trimmed := bytes.TrimSpace(request); len(trimmed) > 0 && trimmed[0] == '[' {
+		return nil, ErrBatchUnsupported
+	}
+
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	name := replyName("fifo")
+	reply := filepath.Join(t.replyDir, name)
+	if err := syscall.Mkfifo(reply, 0666); err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(reply)
+
+	// mkfifo is subject to umask, kamailio must be able to write the reply.
+	if err := os.Chmod(reply, 0666); err != nil {
+		return nil, err
+	}
+
+	request, err := sjson.SetBytes(request, "reply_name", name)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		body []byte
+		err  error
+	}
+
+	// opening a fifo blocks until the other side shows up, so both ends are
+	// handled in a goroutine that is released on cancel.
+	results := make(chan result, 1)
+	go func() {
+		server, err := os.OpenFile(t.path, os.O_WRONLY, 0)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+
+		_, err = server.Write(append(request, '\n'))
+		server.Close()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+
+		replyFile, err := os.OpenFile(reply, os.O_RDONLY, 0)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+
+		defer replyFile.Close()
+		body, err := io.ReadAll(replyFile)
+		results <- result{body: body, err: err}
+	}()
+
+	select {
+	case res := <-results:
+		return res.body, res.err
+	case <-ctx.Done():
+		// unblock a pending open on either fifo.
+		if f, err := os.OpenFile(reply, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+
+		if f, err := os.OpenFile(t.path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+
+		return nil, ctx.Err()
+	}
+}
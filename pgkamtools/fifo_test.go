@@ -0,0 +1,164 @@
+//go:build !windows
+
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFifoTransport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kamailio_rpc.fifo")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type seen struct {
+		req  map[string]any
+		mode os.FileMode
+		err  error
+	}
+
+	requests := make(chan seen, 1)
+	go func() {
+		// stand-in for jsonrpcs: read one request line, answer on the
+		// reply fifo it names inside the reply dir
+		server, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			requests <- seen{err: err}
+			return
+		}
+
+		line, err := bufio.NewReader(server).ReadBytes('\n')
+		server.Close()
+		if err != nil {
+			requests <- seen{err: err}
+			return
+		}
+
+		var req map[string]any
+		if err := json.Unmarshal(line, &req); err != nil {
+			requests <- seen{err: err}
+			return
+		}
+
+		name, _ := req["reply_name"].(string)
+		reply := filepath.Join(dir, name)
+		info, err := os.Stat(reply)
+		if err != nil {
+			requests <- seen{err: err}
+			return
+		}
+
+		requests <- seen{req: req, mode: info.Mode()}
+		f, err := os.OpenFile(reply, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+
+		resp, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": req["id"], "result": "up"})
+		f.Write(resp)
+		f.Close()
+	}()
+
+	c, err := NewClient("fifo://"+path, WithReplyDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result string
+	if err := c.Call(context.Background(), "core.uptime", []any{"x"}, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "up" {
+		t.Errorf("result %q, want up", result)
+	}
+
+	s := <-requests
+	if s.err != nil {
+		t.Fatal(s.err)
+	}
+
+	if s.req["method"] != "core.uptime" || s.req["jsonrpc"] != "2.0" {
+		t.Errorf("request fields lost: %v", s.req)
+	}
+
+	if s.mode&os.ModeNamedPipe == 0 || s.mode.Perm() != 0666 {
+		t.Errorf("reply fifo mode %v, want named pipe 0666", s.mode)
+	}
+
+	leftover, _ := filepath.Glob(filepath.Join(dir, "pgkamtools_*"))
+	if len(leftover) > 0 {
+		t.Errorf("reply fifo not removed: %v", leftover)
+	}
+}
+
+func TestFifoTransportCancel(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kamailio_rpc.fifo")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody reads the fifo, so opening it for the request blocks
+	transport := &fifoTransport{path: path, replyDir: dir}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.RoundTrip(ctx, []byte(`{"jsonrpc":"2.0","method":"core.uptime","id":1}`))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancel did not unblock the fifo open")
+	}
+}
+
+func TestFifoTransportBatch(t *testing.T) {
+	transport := &fifoTransport{path: "/nonexistent", replyDir: t.TempDir()}
+	_, err := transport.RoundTrip(context.Background(), []byte(`[{"jsonrpc":"2.0","method":"core.uptime","id":1}]`))
+	if !errors.Is(err, ErrBatchUnsupported) {
+		t.Fatalf("got %v, want ErrBatchUnsupported", err)
+	}
+}
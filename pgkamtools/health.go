@@ -0,0 +1,153 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// HealthThresholds are the limits of a HealthReport, in percent of the
+// available memory or connections. Zero uses the default.
+type HealthThresholds struct {
+	ShmUsedPercent float64
+	PkgUsedPercent float64
+	TCPUsedPercent float64
+}
+
+// DefaultHealthThresholds is used for every threshold left at zero.
+var DefaultHealthThresholds = HealthThresholds{
+	ShmUsedPercent: 90,
+	PkgUsedPercent: 90,
+	TCPUsedPercent: 90,
+}
+
+// HealthCheck is the result of one threshold.
+type HealthCheck struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Value   float64 `json:"value"`
+	Limit   float64 `json:"limit"`
+	Message string  `json:"message"`
+}
+
+// HealthReport aggregates the memory and tcp usage of a node. Healthy is
+// false when any check is over its threshold. TCP is nil when tcp is
+// disabled on the node.
+type HealthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+	Info    CoreInfo      `json:"info"`
+	Shm     ShmMem        `json:"shm"`
+	Pkg     []PkgStat     `json:"pkg"`
+	TCP     *TCPInfo      `json:"tcp,omitempty"`
+}
+
+// Failed returns the checks over their threshold.
+func (r HealthReport) Failed() []HealthCheck {
+	var failed []HealthCheck
+	for _, check := range r.Checks {
+		if !check.OK {
+			failed = append(failed, check)
+		}
+	}
+
+	return failed
+}
+
+func (t HealthThresholds) withDefaults() HealthThresholds {
+	if t.ShmUsedPercent == 0 {
+		t.ShmUsedPercent = DefaultHealthThresholds.ShmUsedPercent
+	}
+
+	if t.PkgUsedPercent == 0 {
+		t.PkgUsedPercent = DefaultHealthThresholds.PkgUsedPercent
+	}
+
+	if t.TCPUsedPercent == 0 {
+		t.TCPUsedPercent = DefaultHealthThresholds.TCPUsedPercent
+	}
+
+	return t
+}
+
+// Health collects core.info, core.shmmem, pkg.stats and core.tcp_info and
+// checks them against thresholds.
+func (c *Client) Health(ctx context.Context, thresholds HealthThresholds) (HealthReport, error) {
+	thresholds = thresholds.withDefaults()
+	var report HealthReport
+	var err error
+
+	if report.Info, err = c.Info(ctx); err != nil {
+		return report, err
+	}
+
+	if report.Shm, err = c.ShmMem(ctx); err != nil {
+		return report, err
+	}
+
+	if report.Pkg, err = c.PkgStats(ctx); err != nil {
+		return report, err
+	}
+
+	tcp, err := c.TCPInfo(ctx)
+	var rpcErr *RPCError
+	switch {
+	case err == nil:
+		report.TCP = &tcp
+	case !errors.As(err, &rpcErr):
+		return report, err
+	}
+
+	report.Checks = append(report.Checks, usageCheck("shm", float64(report.Shm.RealUsed), float64(report.Shm.Total), thresholds.ShmUsedPercent))
+
+	for _, pkg := range report.Pkg {
+		name := fmt.Sprintf("pkg %d (%s)", pkg.PID, pkg.Description)
+		report.Checks = append(report.Checks, usageCheck(name, float64(pkg.RealUsed), float64(pkg.TotalSize), thresholds.PkgUsedPercent))
+	}
+
+	if report.TCP != nil {
+		report.Checks = append(report.Checks, usageCheck("tcp", float64(tcp.OpenedConnections), float64(tcp.MaxConnections), thresholds.TCPUsedPercent))
+		if tcp.MaxTLSConnections > 0 {
+			report.Checks = append(report.Checks, usageCheck("tls", float64(tcp.OpenedTLSConnections), float64(tcp.MaxTLSConnections), thresholds.TCPUsedPercent))
+		}
+	}
+
+	report.Healthy = len(report.Failed()) == 0
+	return report, nil
+}
+
+func usageCheck(name string, used float64, total float64, limit float64) HealthCheck {
+	check := HealthCheck{Name: name, Limit: limit}
+	if total > 0 {
+		check.Value = used / total * 100
+	}
+
+	check.OK = check.Value < limit
+	check.Message = fmt.Sprintf("%s %.1f%% used (limit %.1f%%)", name, check.Value, limit)
+	return check
+}
@@ -0,0 +1,363 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type HtableType string
+
+const (
+	HtableTypeInt HtableType = "int"
+	HtableTypeStr HtableType = "str"
+)
+
+// HtableValue is a typed htable value. Int is used for HtableTypeInt and
+// Str for HtableTypeStr.
+type HtableValue struct {
+	Type HtableType `json:"type"`
+	Int  int64      `json:"int,omitempty"`
+	Str  string     `json:"str,omitempty"`
+}
+
+func HtableInt(value int64) HtableValue {
+	return HtableValue{Type: HtableTypeInt, Int: value}
+}
+
+func HtableStr(value string) HtableValue {
+	return HtableValue{Type: HtableTypeStr, Str: value}
+}
+
+func (v HtableValue) String() string {
+	if v.Type == HtableTypeInt {
+		return strconv.FormatInt(v.Int, 10)
+	}
+
+	return v.Str
+}
+
+func (v HtableValue) setParams() (string, any) {
+	if v.Type == HtableTypeInt {
+		return "htable.seti", v.Int
+	}
+
+	return "htable.sets", v.Str
+}
+
+// HtableItem is one entry of htable.dump. Slot is the hash slot it lives in.
+type HtableItem struct {
+	Name  string      `json:"name"`
+	Value HtableValue `json:"value"`
+	Slot  int         `json:"slot"`
+}
+
+// HtableStats is the slot usage of a table from htable.stats.
+type HtableStats struct {
+	Name  string `json:"name"`
+	Slots int    `json:"slots"`
+	All   int    `json:"all"`
+	Min   int    `json:"min"`
+	Max   int    `json:"max"`
+}
+
+// HtableInfo is the definition of a table from htable.listTables.
+type HtableInfo struct {
+	Name         string `json:"name"`
+	DbTable      string `json:"dbtable"`
+	DbMode       int    `json:"dbmode"`
+	Expire       int    `json:"expire"`
+	UpdateExpire int    `json:"updateexpire"`
+	Size         int    `json:"size"`
+	DmqReplicate int    `json:"dmqreplicate"`
+}
+
+type htableDumpItem struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+	Type  string          `json:"type"`
+}
+
+func (d htableDumpItem) item(slot int) (HtableItem, error) {
+	item := HtableItem{Name: d.Name, Slot: slot}
+	var err error
+	item.Value, err = parseHtableValue(d.Type, d.Value)
+	return item, err
+}
+
+func parseHtableValue(valueType string, raw json.RawMessage) (HtableValue, error) {
+	var number json.Number
+	if valueType == string(HtableTypeInt) || (valueType == "" && json.Unmarshal(raw, &number) == nil) {
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return HtableValue{}, errors.New("invalid htable int value " + string(raw))
+		}
+
+		value, err := strconv.ParseInt(number.String(), 10, 64)
+		if err != nil {
+			return HtableValue{}, errors.New("invalid htable int value " + string(raw))
+		}
+
+		return HtableInt(value), nil
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return HtableValue{}, errors.New("invalid htable str value " + string(raw))
+	}
+
+	return HtableStr(value), nil
+}
+
+func (c *Client) HtableDelete(ctx context.Context, tableval string, keyval string) (bool, error) {
+	err := c.Call(ctx, "htable.delete", []any{tableval, keyval}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Client) HtableDump(ctx context.Context, tableval string) (string, error) {
+	return c.CallRaw(ctx, "htable.dump", []any{tableval})
+}
+
+func (c *Client) HtableFlush(ctx context.Context, tableval string) (bool, error) {
+	err := c.Call(ctx, "htable.flush", []any{tableval}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Client) HtableGet(ctx context.Context, tableval string, keyval string) (string, error) {
+	getval, err := c.CallRaw(ctx, "htable.get", []any{tableval, keyval})
+	if err != nil {
+		return "", err
+	}
+
+	return HtableParseValueSingle(getval)
+}
+
+// changed 2023-01-18 to treat string as int in json for seti.
+func (c *Client) HtableSetInt(ctx context.Context, tableval string, keyval string, valval string) (bool, error) {
+	err := c.Call(ctx, "htable.seti", []any{tableval, keyval, valval}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Client) HtableSetString(ctx context.Context, tableval string, keyval string, valval string) (bool, error) {
+	err := c.Call(ctx, "htable.sets", []any{tableval, keyval, valval}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// HtableSet uses htable.seti or htable.sets depending on the value type.
+func (c *Client) HtableSet(ctx context.Context, tableval string, keyval string, value HtableValue) error {
+	method, param := value.setParams()
+	return c.Call(ctx, method, []any{tableval, keyval, param}, nil)
+}
+
+// HtableEntries returns all entries of an htable.
+func (c *Client) HtableEntries(ctx context.Context, tableval string) ([]HtableItem, error) {
+	var items []HtableItem
+	err := c.HtableEach(ctx, tableval, func(item HtableItem) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// HtableEach calls fn for every entry of an htable while the dump is read,
+// so large tables are never held in memory. An error from fn stops the dump
+// and is returned.
+func (c *Client) HtableEach(ctx context.Context, tableval string, fn func(HtableItem) error) error {
+	return c.callStream(ctx, "htable.dump", []any{tableval}, func(decoder *json.Decoder) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return invalidResponse(err)
+		}
+
+		if token == nil {
+			return nil
+		}
+
+		if token != json.Delim('[') {
+			return errors.New("invalid htable.dump result")
+		}
+
+		for decoder.More() {
+			if err := htableEachSlot(decoder, fn); err != nil {
+				return err
+			}
+		}
+
+		return expectDelim(decoder, ']')
+	})
+}
+
+func htableEachSlot(decoder *json.Decoder, fn func(HtableItem) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	slot := 0
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return invalidResponse(err)
+		}
+
+		switch key {
+		case "entry":
+			if err := decoder.Decode(&slot); err != nil {
+				return invalidResponse(err)
+			}
+		case "slot":
+			if err := expectDelim(decoder, '['); err != nil {
+				return err
+			}
+
+			for decoder.More() {
+				var dumpItem htableDumpItem
+				if err := decoder.Decode(&dumpItem); err != nil {
+					return invalidResponse(err)
+				}
+
+				item, err := dumpItem.item(slot)
+				if err != nil {
+					return err
+				}
+
+				if err := fn(item); err != nil {
+					return err
+				}
+			}
+
+			if err := expectDelim(decoder, ']'); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return invalidResponse(err)
+			}
+		}
+	}
+
+	return expectDelim(decoder, '}')
+}
+
+func (c *Client) HtableStats(ctx context.Context) ([]HtableStats, error) {
+	return callList[HtableStats](ctx, c, "htable.stats", nil)
+}
+
+func (c *Client) HtableListTables(ctx context.Context) ([]HtableInfo, error) {
+	return callList[HtableInfo](ctx, c, "htable.listTables", nil)
+}
+
+// HtableReload reloads a table from its database.
+func (c *Client) HtableReload(ctx context.Context, tableval string) error {
+	return c.Call(ctx, "htable.reload", []any{tableval}, nil)
+}
+
+// HtableSetExpire changes the expire of an existing key.
+func (c *Client) HtableSetExpire(ctx context.Context, tableval string, keyval string, expire time.Duration) error {
+	return c.Call(ctx, "htable.setex", []any{tableval, keyval, int(expire / time.Second)}, nil)
+}
+
+// HtableSetWithExpire sets a key with its own expire, using htable.setxi or
+// htable.setxs depending on the value type.
+func (c *Client) HtableSetWithExpire(ctx context.Context, tableval string, keyval string, value HtableValue, expire time.Duration) error {
+	method := "htable.setxs"
+	var param any = value.Str
+	if value.Type == HtableTypeInt {
+		method = "htable.setxi"
+		param = value.Int
+	}
+
+	return c.Call(ctx, method, []any{tableval, keyval, param, int(expire / time.Second)}, nil)
+}
+
+// HtableDeleteMatching deletes every key matching filter and returns the
+// number of keys deleted. Kamailio has no rpc for this, so the table is
+// dumped and the deletes are sent in batches. An empty filter is refused;
+// use HtableFlush to clear a table.
+func (c *Client) HtableDeleteMatching(ctx context.Context, tableval string, filter HtableFilter) (int, error) {
+	if filter.Prefix == "" && filter.Regexp == nil {
+		return 0, errors.New("empty htable filter, use HtableFlush to delete every key")
+	}
+
+	batch := c.NewBatch()
+	err := c.HtableEach(ctx, tableval, func(item HtableItem) error {
+		if filter.Match(item.Name) {
+			batch.HtableDelete(tableval, item.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = batch.Send(ctx)
+	deleted := 0
+	for _, item := range batch.Items() {
+		if item.Err == nil {
+			deleted++
+		}
+	}
+
+	return deleted, err
+}
+
+func (c *Client) HtableDeletePrefix(ctx context.Context, tableval string, prefix string) (int, error) {
+	return c.HtableDeleteMatching(ctx, tableval, HtableFilter{Prefix: prefix})
+}
+
+func (c *Client) HtableDeleteRegexp(ctx context.Context, tableval string, expr string) (int, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.HtableDeleteMatching(ctx, tableval, HtableFilter{Regexp: re})
+}
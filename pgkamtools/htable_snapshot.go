@@ -0,0 +1,367 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HtableSnapshotVersion is the version written to snapshot files.
+const HtableSnapshotVersion = 1
+
+const htableSnapshotCsvMagic = "pgkamtools_htable_snapshot"
+
+type HtableSnapshotFormat int
+
+const (
+	// HtableSnapshotAuto picks csv for .csv files and json otherwise.
+	HtableSnapshotAuto HtableSnapshotFormat = iota
+	HtableSnapshotJSON
+	HtableSnapshotCSV
+)
+
+// HtableSnapshot is a saved copy of an htable.
+type HtableSnapshot struct {
+	Version int
+	Table   string
+	Created time.Time
+	Items   []HtableItem
+}
+
+// HtableFilter selects keys by prefix and/or regular expression. The zero
+// value matches every key.
+type HtableFilter struct {
+	Prefix string
+	Regexp *regexp.Regexp
+}
+
+type HtableExportOptions struct {
+	Format HtableSnapshotFormat
+	Filter HtableFilter
+}
+
+type HtableImportOptions struct {
+	Format HtableSnapshotFormat
+	Filter HtableFilter
+
+	// Table imports into a different table than the one in the snapshot.
+	Table string
+
+	// Replace deletes live keys (matching Filter) that are not in the
+	// snapshot. Otherwise the snapshot is merged into the table.
+	Replace bool
+
+	// DryRun returns the plan without changing anything.
+	DryRun bool
+}
+
+type htableSnapshotJson struct {
+	Version int                  `json:"version"`
+	Table   string               `json:"table"`
+	Created time.Time            `json:"created"`
+	Items   []htableSnapshotItem `json:"items"`
+}
+
+type htableSnapshotItem struct {
+	Name  string          `json:"name"`
+	Type  HtableType      `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (f HtableFilter) Match(key string) bool {
+	if f.Prefix != "" && !strings.HasPrefix(key, f.Prefix) {
+		return false
+	}
+
+	if f.Regexp != nil && !f.Regexp.MatchString(key) {
+		return false
+	}
+
+	return true
+}
+
+// HtableSnapshot dumps table into a snapshot, keeping the keys that match
+// filter.
+func (c *Client) HtableSnapshot(ctx context.Context, table string, filter HtableFilter) (*HtableSnapshot, error) {
+	snapshot := &HtableSnapshot{
+		Version: HtableSnapshotVersion,
+		Table:   table,
+		Created: time.Now().UTC(),
+	}
+
+	err := c.HtableEach(ctx, table, func(item HtableItem) error {
+		if filter.Match(item.Name) {
+			snapshot.Items = append(snapshot.Items, item)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// ExportHtable writes a snapshot of table to path.
+func (c *Client) ExportHtable(ctx context.Context, table string, path string, opts HtableExportOptions) (*HtableSnapshot, error) {
+	snapshot, err := c.HtableSnapshot(ctx, table, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, snapshot.Save(path, opts.Format)
+}
+
+// ImportHtable loads the snapshot at path into Kamailio.
+func (c *Client) ImportHtable(ctx context.Context, path string, opts HtableImportOptions) (*HtablePlan, error) {
+	snapshot, err := ReadHtableSnapshot(path, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.RestoreHtableSnapshot(ctx, snapshot, opts)
+}
+
+// RestoreHtableSnapshot applies a snapshot with htable.sets / htable.seti
+// according to each value type. Only keys matching opts.Filter are touched.
+func (c *Client) RestoreHtableSnapshot(ctx context.Context, snapshot *HtableSnapshot, opts HtableImportOptions) (*HtablePlan, error) {
+	table := snapshot.Table
+	if opts.Table != "" {
+		table = opts.Table
+	}
+
+	if table == "" {
+		return nil, errors.New("snapshot has no table name")
+	}
+
+	desired := map[string]HtableValue{}
+	for _, item := range snapshot.Items {
+		if opts.Filter.Match(item.Name) {
+			desired[item.Name] = item.Value
+		}
+	}
+
+	var live []HtableItem
+	err := c.HtableEach(ctx, table, func(item HtableItem) error {
+		if opts.Filter.Match(item.Name) {
+			live = append(live, item)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := PlanHtable(table, live, desired, !opts.Replace)
+	if opts.DryRun || plan.Empty() {
+		return plan, nil
+	}
+
+	return plan, c.ApplyHtablePlan(ctx, plan)
+}
+
+func snapshotFormat(path string, format HtableSnapshotFormat) HtableSnapshotFormat {
+	if format != HtableSnapshotAuto {
+		return format
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return HtableSnapshotCSV
+	}
+
+	return HtableSnapshotJSON
+}
+
+// Save writes the snapshot to path through a temporary file.
+func (s *HtableSnapshot) Save(path string, format HtableSnapshotFormat) error {
+	return saveFile(path, ".htable-snapshot.*", func(w io.Writer) error {
+		if snapshotFormat(path, format) == HtableSnapshotCSV {
+			return s.WriteCSV(w)
+		}
+
+		return s.WriteJSON(w)
+	})
+}
+
+func ReadHtableSnapshot(path string, format HtableSnapshotFormat) (*HtableSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	if snapshotFormat(path, format) == HtableSnapshotCSV {
+		return ReadHtableSnapshotCSV(f)
+	}
+
+	return ReadHtableSnapshotJSON(f)
+}
+
+func (s *HtableSnapshot) WriteJSON(w io.Writer) error {
+	out := htableSnapshotJson{
+		Version: s.Version,
+		Table:   s.Table,
+		Created: s.Created,
+		Items:   make([]htableSnapshotItem, 0, len(s.Items)),
+	}
+
+	for _, item := range s.Items {
+		var value []byte
+		if item.Value.Type == HtableTypeInt {
+			value = []byte(strconv.FormatInt(item.Value.Int, 10))
+		} else {
+			value, _ = json.Marshal(item.Value.Str)
+		}
+
+		out.Items = append(out.Items, htableSnapshotItem{
+			Name:  item.Name,
+			Type:  item.Value.Type,
+			Value: value,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(out)
+}
+
+func ReadHtableSnapshotJSON(r io.Reader) (*HtableSnapshot, error) {
+	var in htableSnapshotJson
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, err
+	}
+
+	if in.Version < 1 || in.Version > HtableSnapshotVersion {
+		return nil, fmt.Errorf("unsupported htable snapshot version %d", in.Version)
+	}
+
+	snapshot := &HtableSnapshot{
+		Version: in.Version,
+		Table:   in.Table,
+		Created: in.Created,
+	}
+
+	for _, item := range in.Items {
+		value, err := parseHtableValue(string(item.Type), item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("htable snapshot key %s: %w", item.Name, err)
+		}
+
+		snapshot.Items = append(snapshot.Items, HtableItem{Name: item.Name, Value: value})
+	}
+
+	return snapshot, nil
+}
+
+// WriteCSV writes a header record (magic, version, table, created), a
+// column record and then one name,type,value record per item.
+func (s *HtableSnapshot) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{htableSnapshotCsvMagic, strconv.Itoa(s.Version), s.Table, s.Created.Format(time.RFC3339)})
+	writer.Write([]string{"name", "type", "value"})
+	for _, item := range s.Items {
+		writer.Write([]string{item.Name, string(item.Value.Type), item.Value.String()})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func ReadHtableSnapshotCSV(r io.Reader) (*HtableSnapshot, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) < 4 || header[0] != htableSnapshotCsvMagic {
+		return nil, errors.New("not an htable snapshot csv file")
+	}
+
+	snapshot := &HtableSnapshot{Table: header[2]}
+	snapshot.Version, err = strconv.Atoi(header[1])
+	if err != nil || snapshot.Version < 1 || snapshot.Version > HtableSnapshotVersion {
+		return nil, errors.New("unsupported htable snapshot version " + header[1])
+	}
+
+	snapshot.Created, err = time.Parse(time.RFC3339, header[3])
+	if err != nil {
+		return nil, err
+	}
+
+	// column names
+	if _, err := reader.Read(); err != nil {
+		return nil, err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) != 3 {
+			return nil, errors.New("htable snapshot record must have name,type,value")
+		}
+
+		item := HtableItem{Name: record[0]}
+		switch HtableType(record[1]) {
+		case HtableTypeInt:
+			value, err := strconv.ParseInt(record[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("htable snapshot key %s: invalid int %s", record[0], record[2])
+			}
+
+			item.Value = HtableInt(value)
+		case HtableTypeStr:
+			item.Value = HtableStr(record[2])
+		default:
+			return nil, fmt.Errorf("htable snapshot key %s: unknown type %s", record[0], record[1])
+		}
+
+		snapshot.Items = append(snapshot.Items, item)
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,142 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"sort"
+)
+
+// HtableChange is one key of an HtablePlan. Old is nil for added keys and
+// New is nil for deleted keys.
+type HtableChange struct {
+	Key string       `json:"key"`
+	Old *HtableValue `json:"old,omitempty"`
+	New *HtableValue `json:"new,omitempty"`
+}
+
+// HtablePlan lists the changes needed to make a live htable match a desired
+// state.
+type HtablePlan struct {
+	Table     string         `json:"table"`
+	Add       []HtableChange `json:"add"`
+	Update    []HtableChange `json:"update"`
+	Delete    []HtableChange `json:"delete"`
+	Unchanged int            `json:"unchanged"`
+}
+
+type HtableReconcileOptions struct {
+	// DryRun returns the plan without changing anything.
+	DryRun bool
+
+	// KeepExtra leaves live keys that are not in the desired state.
+	KeepExtra bool
+}
+
+func (p *HtablePlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// PlanHtable computes the changes from live entries to desired. A value that
+// changes type counts as an update. A desired value without a Type is a
+// string, the same as HtableSet sends it.
+func PlanHtable(table string, live []HtableItem, desired map[string]HtableValue, keepExtra bool) *HtablePlan {
+	plan := &HtablePlan{Table: table}
+	current := make(map[string]HtableValue, len(live))
+	for _, item := range live {
+		current[item.Name] = item.Value
+	}
+
+	for key, value := range desired {
+		value := value
+		if value.Type == "" {
+			value = HtableStr(value.Str)
+		}
+
+		old, ok := current[key]
+		switch {
+		case !ok:
+			plan.Add = append(plan.Add, HtableChange{Key: key, New: &value})
+		case old != value:
+			plan.Update = append(plan.Update, HtableChange{Key: key, Old: &old, New: &value})
+		default:
+			plan.Unchanged++
+		}
+	}
+
+	if !keepExtra {
+		for key, value := range current {
+			value := value
+			if _, ok := desired[key]; !ok {
+				plan.Delete = append(plan.Delete, HtableChange{Key: key, Old: &value})
+			}
+		}
+	}
+
+	for _, changes := range [][]HtableChange{plan.Add, plan.Update, plan.Delete} {
+		changes := changes
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Key < changes[j].Key
+		})
+	}
+
+	return plan
+}
+
+// HtableReconcile makes the live htable match desired by applying only the
+// difference, sets first and deletes last so the table is never empty. The
+// calls are sent in batches.
+func (c *Client) HtableReconcile(ctx context.Context, table string, desired map[string]HtableValue, opts HtableReconcileOptions) (*HtablePlan, error) {
+	live, err := c.HtableEntries(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := PlanHtable(table, live, desired, opts.KeepExtra)
+	if opts.DryRun || plan.Empty() {
+		return plan, nil
+	}
+
+	return plan, c.ApplyHtablePlan(ctx, plan)
+}
+
+// ApplyHtablePlan sends the changes of plan to Kamailio.
+func (c *Client) ApplyHtablePlan(ctx context.Context, plan *HtablePlan) error {
+	batch := c.NewBatch()
+	for _, change := range plan.Add {
+		batch.HtableSet(plan.Table, change.Key, *change.New)
+	}
+
+	for _, change := range plan.Update {
+		batch.HtableSet(plan.Table, change.Key, *change.New)
+	}
+
+	for _, change := range plan.Delete {
+		batch.HtableDelete(plan.Table, change.Key)
+	}
+
+	return batch.Send(ctx)
+}
@@ -0,0 +1,366 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RPCError is the error object returned by Kamailio when a call fails.
+// Use errors.As to get the code and message.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("kamailio rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	Jsonrpc string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	Id      int64  `json:"id"`
+}
+
+type rpcResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error"`
+}
+
+var lastRpcId = time.Now().UnixMicro()
+
+func nextId() int64 {
+	return atomic.AddInt64(&lastRpcId, 1)
+}
+
+// Call sends method with params (usually a []any of positional values, or
+// nil) and decodes the result into result, which may be nil. A failure
+// reported by Kamailio is returned as *RPCError.
+func (c *Client) Call(ctx context.Context, method string, params any, result any) error {
+	resp, _, err := c.call(ctx, method, params)
+	if err != nil {
+		return err
+	}
+
+	return decodeResult(resp.Result, result)
+}
+
+// CallRaw sends method with params and returns the full json response,
+// for use with the gjson based parse functions.
+func (c *Client) CallRaw(ctx context.Context, method string, params any) (string, error) {
+	_, raw, err := c.call(ctx, method, params)
+	if err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+func (c *Client) call(ctx context.Context, method string, params any) (*rpcResponse, string, error) {
+	id := nextId()
+	sendJson, err := json.Marshal(rpcRequest{
+		Jsonrpc: "2.0",
+		Method:  method,
+		Params:  params,
+		Id:      id,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	raw, err := c.SendJson(ctx, string(sendJson))
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := parseResponse([]byte(raw))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := resp.check(id); err != nil {
+		return nil, "", err
+	}
+
+	return resp, raw, nil
+}
+
+// callStream decodes the response while it is read. handle is given the
+// decoder positioned at the result value and must consume it. The id is
+// checked once the whole response has been read.
+func (c *Client) callStream(ctx context.Context, method string, params any, handle func(*json.Decoder) error) error {
+	id := nextId()
+	sendJson, err := json.Marshal(rpcRequest{
+		Jsonrpc: "2.0",
+		Method:  method,
+		Params:  params,
+		Id:      id,
+	})
+	if err != nil {
+		return err
+	}
+
+	stream, err := roundTripStream(ctx, c.rpc, sendJson)
+	if err != nil {
+		return err
+	}
+
+	defer stream.Close()
+	decoder := json.NewDecoder(stream)
+	decoder.UseNumber()
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	resp := rpcResponse{}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return invalidResponse(err)
+		}
+
+		switch key {
+		case "id":
+			err = decoder.Decode(&resp.Id)
+		case "error":
+			resp.Error = &RPCError{}
+			err = decoder.Decode(resp.Error)
+			if err == nil {
+				return resp.Error
+			}
+		case "result":
+			err = handle(decoder)
+		default:
+			var skip json.RawMessage
+			err = decoder.Decode(&skip)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return resp.check(id)
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return invalidResponse(err)
+	}
+
+	if token != delim {
+		return fmt.Errorf("invalid response from kamailio: expected %v, got %v", delim, token)
+	}
+
+	return nil
+}
+
+func invalidResponse(err error) error {
+	return errors.New("invalid response from kamailio: " + err.Error())
+}
+
+func parseResponse(raw []byte) (*rpcResponse, error) {
+	var resp rpcResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, invalidResponse(err)
+	}
+
+	return &resp, nil
+}
+
+func (r *rpcResponse) check(id int64) error {
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if !idMatches(r.Id, id) {
+		return errors.New("kamailio response id " + string(r.Id) + " does not match request id " + strconv.FormatInt(id, 10))
+	}
+
+	return nil
+}
+
+// kamailio echoes the id back, but may quote it.
+func idMatches(raw json.RawMessage, id int64) bool {
+	raw = bytes.Trim(bytes.TrimSpace(raw), `"`)
+	return string(raw) == strconv.FormatInt(id, 10)
+}
+
+// decodeList decodes a result that kamailio prints as a single value when
+// there is one item and as an array otherwise.
+func decodeList[T any](raw json.RawMessage) ([]T, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var list []T
+	if raw[0] != '[' {
+		var single T
+		if err := decodeResult(raw, &single); err != nil {
+			return nil, err
+		}
+
+		return append(list, single), nil
+	}
+
+	if err := decodeResult(raw, &list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// decodeRepeated decodes a result where kamailio adds each item under the
+// same struct member name, ie {"socket": {...}, "socket": {...}}. An array of
+// such structs, a member holding an array and a single item work as well.
+func decodeRepeated[T any](raw json.RawMessage, key string) ([]T, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	if raw[0] == '[' {
+		var elems []json.RawMessage
+		if err := decodeResult(raw, &elems); err != nil {
+			return nil, err
+		}
+
+		var list []T
+		for _, elem := range elems {
+			items, err := decodeRepeated[T](elem, key)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, items...)
+		}
+
+		return list, nil
+	}
+
+	if raw[0] != '{' {
+		return decodeList[T](raw)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+
+	var list []T
+	found := false
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, invalidResponse(err)
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, invalidResponse(err)
+		}
+
+		if name, _ := token.(string); name != key {
+			continue
+		}
+
+		found = true
+		items, err := decodeList[T](value)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, items...)
+	}
+
+	if !found {
+		return decodeList[T](raw)
+	}
+
+	return list, nil
+}
+
+// callList calls method and decodes its result with decodeList.
+func callList[T any](ctx context.Context, c *Client, method string, params any) ([]T, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, method, params, &raw); err != nil {
+		return nil, err
+	}
+
+	return decodeList[T](raw)
+}
+
+// callCount calls method and returns its count result. Kamailio prints the
+// count either as a bare number or, when key is set, as the key member of a
+// struct (dlg.profile_get_size answers with name, value and count).
+func (c *Client) callCount(ctx context.Context, method string, key string, params ...any) (int64, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, method, params, &raw); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := json.Unmarshal(raw, &count); err == nil {
+		return count, nil
+	}
+
+	if key != "" {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err == nil {
+			if err := json.Unmarshal(fields[key], &count); err == nil {
+				return count, nil
+			}
+		}
+	}
+
+	return 0, errors.New("unexpected " + method + " result: " + string(raw))
+}
+
+func decodeResult(raw json.RawMessage, result any) error {
+	if result == nil || len(raw) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, result); err != nil {
+		return errors.New("unable to decode kamailio result: " + err.Error())
+	}
+
+	return nil
+}
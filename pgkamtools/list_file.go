@@ -0,0 +1,143 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// listFileLine is a line of a Kamailio list file (dispatcher.list,
+// address.list): either an entry or a comment/blank line (Text).
+type listFileLine[E fmt.Stringer] struct {
+	Entry   *E
+	Text    string
+	Comment string
+}
+
+// parseListFile reads a list file with parse for the entry lines. Comments
+// and blank lines are kept so the file can be written back as it was; name
+// prefixes the line number of parse errors.
+func parseListFile[E fmt.Stringer](r io.Reader, name string, parse func(string) (E, error)) ([]listFileLine[E], error) {
+	var lines []listFileLine[E]
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			lines = append(lines, listFileLine[E]{Text: text})
+			continue
+		}
+
+		line := listFileLine[E]{}
+		if i := strings.Index(trimmed, " #"); i >= 0 {
+			line.Comment = strings.TrimSpace(trimmed[i+2:])
+			trimmed = strings.TrimSpace(trimmed[:i])
+		}
+
+		entry, err := parse(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", name, lineNum, err)
+		}
+
+		line.Entry = &entry
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+func writeListFile[E fmt.Stringer](w io.Writer, lines []listFileLine[E]) (int64, error) {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if line.Entry == nil {
+			buf.WriteString(line.Text + "\n")
+			continue
+		}
+
+		buf.WriteString((*line.Entry).String())
+		if line.Comment != "" {
+			buf.WriteString(" # " + line.Comment)
+		}
+
+		buf.WriteString("\n")
+	}
+
+	return buf.WriteTo(w)
+}
+
+func listFileEntries[E fmt.Stringer](lines []listFileLine[E]) []E {
+	var entries []E
+	for _, line := range lines {
+		if line.Entry != nil {
+			entries = append(entries, *line.Entry)
+		}
+	}
+
+	return entries
+}
+
+// saveFile writes path through a temporary file (named after pattern, see
+// os.CreateTemp) and a rename, so Kamailio never loads a half written file.
+// The mode of an existing file is kept, new files get 0644.
+func saveFile(path string, pattern string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), pattern)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
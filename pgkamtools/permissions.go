@@ -0,0 +1,232 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PermissionsDomain is one entry of permissions.domainDump, an address
+// entry given by name.
+type PermissionsDomain struct {
+	Group  int    `json:"group"`
+	Domain string `json:"domain"`
+	Port   int    `json:"port"`
+	Tag    string `json:"tag"`
+}
+
+// PermissionsTrusted is one entry of permissions.trustedDump.
+type PermissionsTrusted struct {
+	SrcIP       string `json:"src_ip"`
+	Proto       string `json:"proto"`
+	Pattern     string `json:"pattern"`
+	RURIPattern string `json:"ruri_pattern"`
+	Tag         string `json:"tag"`
+	Priority    int    `json:"priority"`
+}
+
+type permissionsJson struct {
+	Group       json.Number `json:"group"`
+	IP          string      `json:"ip"`
+	Mask        json.Number `json:"mask"`
+	Port        json.Number `json:"port"`
+	Tag         string      `json:"tag"`
+	DomainName  string      `json:"domain_name"`
+	SrcIP       string      `json:"src_ip"`
+	Proto       any         `json:"proto"`
+	Pattern     string      `json:"pattern"`
+	RURIPattern string      `json:"ruri_pattern"`
+	Priority    json.Number `json:"priority"`
+}
+
+// permissionsTag drops the "NULL" kamailio prints for a missing tag or
+// pattern.
+func permissionsTag(tag string) string {
+	if tag == "NULL" {
+		return ""
+	}
+
+	return tag
+}
+
+// callPermissions decodes the dumps of permissions, where the entries can be
+// nested in "item" structs of each hash table slot.
+func (c *Client) callPermissions(ctx context.Context, method string) ([]permissionsJson, error) {
+	rows, err := callList[json.RawMessage](ctx, c, method, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []permissionsJson
+	for _, row := range rows {
+		items, err := decodeRepeated[permissionsJson](row, "item")
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, items...)
+	}
+
+	return list, nil
+}
+
+func (c *Client) permissionsAddresses(ctx context.Context, method string) (AddressList, error) {
+	list, err := c.callPermissions(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make(AddressList, 0, len(list))
+	for _, p := range list {
+		entry := AddressEntry{
+			Group: int(numberInt(p.Group)),
+			IP:    p.IP,
+			Mask:  int(numberInt(p.Mask)),
+			Port:  int(numberInt(p.Port)),
+			Tag:   permissionsTag(p.Tag),
+		}
+
+		if entry.Mask == 0 {
+			entry.Mask = fullMask(entry.IP)
+		}
+
+		addresses = append(addresses, entry)
+	}
+
+	return addresses, nil
+}
+
+// PermissionsAddressDump returns the single addresses of the address table
+// (permissions.addressDump).
+func (c *Client) PermissionsAddressDump(ctx context.Context) (AddressList, error) {
+	return c.permissionsAddresses(ctx, "permissions.addressDump")
+}
+
+// PermissionsSubnetDump returns the subnets of the address table
+// (permissions.subnetDump).
+func (c *Client) PermissionsSubnetDump(ctx context.Context) (AddressList, error) {
+	return c.permissionsAddresses(ctx, "permissions.subnetDump")
+}
+
+// PermissionsAddresses returns the single addresses and subnets of the
+// address table together.
+func (c *Client) PermissionsAddresses(ctx context.Context) (AddressList, error) {
+	addresses, err := c.PermissionsAddressDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets, err := c.PermissionsSubnetDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(addresses, subnets...), nil
+}
+
+// PermissionsDomainDump returns the address entries given by domain name
+// (permissions.domainDump).
+func (c *Client) PermissionsDomainDump(ctx context.Context) ([]PermissionsDomain, error) {
+	list, err := c.callPermissions(ctx, "permissions.domainDump")
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make([]PermissionsDomain, 0, len(list))
+	for _, p := range list {
+		domains = append(domains, PermissionsDomain{
+			Group:  int(numberInt(p.Group)),
+			Domain: p.DomainName,
+			Port:   int(numberInt(p.Port)),
+			Tag:    permissionsTag(p.Tag),
+		})
+	}
+
+	return domains, nil
+}
+
+// PermissionsTrustedDump returns the trusted table (permissions.trustedDump).
+func (c *Client) PermissionsTrustedDump(ctx context.Context) ([]PermissionsTrusted, error) {
+	list, err := c.callPermissions(ctx, "permissions.trustedDump")
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := make([]PermissionsTrusted, 0, len(list))
+	for _, p := range list {
+		entry := PermissionsTrusted{
+			SrcIP:       p.SrcIP,
+			Pattern:     permissionsTag(p.Pattern),
+			RURIPattern: permissionsTag(p.RURIPattern),
+			Tag:         permissionsTag(p.Tag),
+			Priority:    int(numberInt(p.Priority)),
+		}
+
+		if entry.SrcIP == "" {
+			entry.SrcIP = p.IP
+		}
+
+		if p.Proto != nil {
+			entry.Proto = fmt.Sprint(p.Proto)
+		}
+
+		trusted = append(trusted, entry)
+	}
+
+	return trusted, nil
+}
+
+func (c *Client) PermissionsAddressReload(ctx context.Context) error {
+	return c.Call(ctx, "permissions.addressReload", nil, nil)
+}
+
+func (c *Client) PermissionsTrustedReload(ctx context.Context) error {
+	return c.Call(ctx, "permissions.trustedReload", nil, nil)
+}
+
+// PermissionsTestURI checks uri and contact against the allow/deny files
+// loaded under basename (permissions.testUri).
+func (c *Client) PermissionsTestURI(ctx context.Context, basename string, uri string, contact string) (bool, error) {
+	var result string
+	if err := c.Call(ctx, "permissions.testUri", []any{basename, uri, contact}, &result); err != nil {
+		return false, err
+	}
+
+	return strings.EqualFold(strings.TrimSpace(result), "allowed"), nil
+}
+
+// fullMask returns the host mask for ip, 32 or 128.
+func fullMask(ip string) int {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return 128
+	}
+
+	return 32
+}
@@ -0,0 +1,217 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AddressEntry is one entry of the permissions address table or file:
+//
+//	group ip [mask [port [tag]]]
+//
+// Port 0 matches any port.
+type AddressEntry struct {
+	Group int    `json:"group"`
+	IP    string `json:"ip"`
+	Mask  int    `json:"mask"`
+	Port  int    `json:"port"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+type AddressList []AddressEntry
+
+// AddressFile is a parsed permissions address file. Comments and blank lines
+// are kept so the file can be written back as it was.
+type AddressFile struct {
+	Lines []AddressFileLine
+}
+
+// AddressFileLine is either an entry or a comment/blank line (Text).
+type AddressFileLine = listFileLine[AddressEntry]
+
+func ParseAddressFile(r io.Reader) (*AddressFile, error) {
+	lines, err := parseListFile(r, "address file", parseAddressEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AddressFile{Lines: lines}, nil
+}
+
+func parseAddressEntry(text string) (AddressEntry, error) {
+	var entry AddressEntry
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return entry, errors.New("expected group and ip")
+	}
+
+	var err error
+	entry.Group, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return entry, errors.New("invalid group " + fields[0])
+	}
+
+	entry.IP = fields[1]
+	if net.ParseIP(entry.IP) == nil {
+		return entry, errors.New("invalid ip " + fields[1])
+	}
+
+	entry.Mask = fullMask(entry.IP)
+	if len(fields) > 2 {
+		entry.Mask, err = strconv.Atoi(fields[2])
+		if err != nil || entry.Mask < 0 || entry.Mask > fullMask(entry.IP) {
+			return entry, errors.New("invalid mask " + fields[2])
+		}
+	}
+
+	if len(fields) > 3 {
+		entry.Port, err = strconv.Atoi(fields[3])
+		if err != nil || entry.Port < 0 || entry.Port > 65535 {
+			return entry, errors.New("invalid port " + fields[3])
+		}
+	}
+
+	if len(fields) > 4 {
+		entry.Tag = strings.Join(fields[4:], " ")
+	}
+
+	return entry, nil
+}
+
+func ReadAddressFile(path string) (*AddressFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ParseAddressFile(f)
+}
+
+func (e AddressEntry) String() string {
+	line := strconv.Itoa(e.Group) + " " + e.IP + " " + strconv.Itoa(e.Mask) + " " + strconv.Itoa(e.Port)
+	if e.Tag != "" {
+		line += " " + e.Tag
+	}
+
+	return line
+}
+
+// Match reports whether ip and port are covered by the entry.
+func (e AddressEntry) Match(ip string, port int) bool {
+	if e.Port != 0 && e.Port != port {
+		return false
+	}
+
+	addr := net.ParseIP(ip)
+	entryIP := net.ParseIP(e.IP)
+	if addr == nil || entryIP == nil {
+		return false
+	}
+
+	bits := 128
+	if entryIP.To4() != nil {
+		bits = 32
+		entryIP = entryIP.To4()
+		if addr = addr.To4(); addr == nil {
+			return false
+		}
+	} else if addr.To4() != nil {
+		return false
+	}
+
+	network := net.IPNet{IP: entryIP.Mask(net.CIDRMask(e.Mask, bits)), Mask: net.CIDRMask(e.Mask, bits)}
+	return network.Contains(addr)
+}
+
+// Match returns the entries covering ip and port, of any group.
+func (l AddressList) Match(ip string, port int) AddressList {
+	var found AddressList
+	for _, entry := range l {
+		if entry.Match(ip, port) {
+			found = append(found, entry)
+		}
+	}
+
+	return found
+}
+
+// Allowed reports whether ip and port are allowed in group, like
+// allow_address(group, ip, port).
+func (l AddressList) Allowed(group int, ip string, port int) bool {
+	for _, entry := range l.Match(ip, port) {
+		if entry.Group == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *AddressFile) WriteTo(w io.Writer) (int64, error) {
+	return writeListFile(w, f.Lines)
+}
+
+// Save replaces the address file at path.
+func (f *AddressFile) Save(path string) error {
+	return saveFile(path, ".address.list.*", func(w io.Writer) error {
+		_, err := f.WriteTo(w)
+		return err
+	})
+}
+
+func (f *AddressFile) Entries() AddressList {
+	return listFileEntries(f.Lines)
+}
+
+// Add appends an entry to the file.
+func (f *AddressFile) Add(entry AddressEntry) {
+	f.Lines = append(f.Lines, AddressFileLine{Entry: &entry})
+}
+
+// Remove drops the entries of group with ip and mask, returning how many
+// were removed.
+func (f *AddressFile) Remove(group int, ip string, mask int) int {
+	removed := 0
+	lines := f.Lines[:0]
+	for _, line := range f.Lines {
+		if line.Entry != nil && line.Entry.Group == group && line.Entry.IP == ip && line.Entry.Mask == mask {
+			removed++
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	f.Lines = lines
+	return removed
+}
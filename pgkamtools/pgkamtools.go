@@ -26,6 +26,7 @@ SOFTWARE.
 package pgkamtools
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -35,7 +36,6 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/tidwall/gjson"
@@ -140,194 +140,48 @@ func CheckFields(mapstring map[string]string, reqfields []string) (bool, error)
 }
 
 func DispatcherAdd(groupval string, addressval string, urlval string) (string, error) {
-	sendJson, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJson, _ = sjson.Set(sendJson, "method", "dispatcher.add")
-	sendJson, _ = sjson.Set(sendJson, "params.group", groupval)
-	sendJson, _ = sjson.Set(sendJson, "params.address", addressval)
-	sendJson, _ = sjson.Set(sendJson, "id", getId())
-
-	results, err := SendJsonhttp(sendJson, urlval)
-	if err != nil {
-		return "", err
-	}
-
-	return results, nil
+	return defaultClient(urlval).DispatcherAdd(context.Background(), groupval, addressval)
 }
 
 func DispatcherList(urlval string) (string, error) {
-	sendJson, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJson, _ = sjson.Set(sendJson, "method", "dispatcher.list")
-	sendJson, _ = sjson.Set(sendJson, "id", getId())
-
-	results, err := SendJsonhttp(sendJson, urlval)
-	if err != nil {
-		return "", err
-	}
-
-	return results, nil
-
+	return defaultClient(urlval).DispatcherList(context.Background())
 }
 
 func DispatcherListSimple(urlval string) (string, error) {
-	sendJson, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJson, _ = sjson.Set(sendJson, "method", "dispatcher.list")
-	sendJson, _ = sjson.Set(sendJson, "id", getId())
-
-	results, err := SendJsonhttp(sendJson, urlval)
-	if err != nil {
-		return "", err
-	}
-
-	if !gjson.Valid(results) {
-		return "", errors.New("invalid response from kamailio")
-	}
-
-	if gjson.Get(results, "error.message").Exists() {
-		errstring := gjson.Get(results, "error.message")
-		return "", errors.New(errstring.String())
-	}
-
-	resultJson := gjson.Get(results, "result.RECORDS.#[@flatten].SET.TARGETS.#.DEST.URI")
-	var jsonResult string
-	for _, nodeValue := range resultJson.Array() {
-		jsonResult, _ = sjson.Set(jsonResult, "nodes.-1", nodeValue.Str)
-	}
-
-	return jsonResult, nil
+	return defaultClient(urlval).DispatcherListSimple(context.Background())
 }
 
 func DispatcherListByGroup(urlval string) (string, error) {
-	sendJson, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJson, _ = sjson.Set(sendJson, "method", "dispatcher.list")
-	sendJson, _ = sjson.Set(sendJson, "id", getId())
-
-	results, err := SendJsonhttp(sendJson, urlval)
-	if err != nil {
-		return "", err
-	}
-
-	if !gjson.Valid(results) {
-		return "", errors.New("invalid response from kamailio")
-	}
-
-	if gjson.Get(results, "error.message").Exists() {
-		errstring := gjson.Get(results, "error.message")
-		return "", errors.New(errstring.String())
-	}
-
-	resultJson := gjson.Get(results, "result.RECORDS.#.SET.{id:ID,nodes:TARGETS.#.{uri:DEST.URI,flags:DEST.FLAGS,priority:DEST.PRIORITY,latency:DEST.LATENCY.AVG}}")
-	return resultJson.String(), nil
+	return defaultClient(urlval).DispatcherListByGroup(context.Background())
 }
 
 func DispatcherRemove(groupval string, addressval string, urlval string) (string, error) {
-	sendJson, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJson, _ = sjson.Set(sendJson, "method", "dispatcher.remove")
-	sendJson, _ = sjson.Set(sendJson, "params.group", groupval)
-	sendJson, _ = sjson.Set(sendJson, "params.address", addressval)
-	sendJson, _ = sjson.Set(sendJson, "id", getId())
-
-	results, err := SendJsonhttp(sendJson, urlval)
-	if err != nil {
-		return "", err
-	}
-
-	return results, nil
+	return defaultClient(urlval).DispatcherRemove(context.Background(), groupval, addressval)
 }
 
 func HtableDelete(tableval string, keyval string, urlval string) (bool, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "htable.delete")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.htable", tableval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.key", keyval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	_, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return defaultClient(urlval).HtableDelete(context.Background(), tableval, keyval)
 }
 
 func HtableDump(tableval string, urlval string) (string, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "htable.dump")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.htable", tableval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	htableresult, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return "", err
-	}
-
-	return htableresult, nil
+	return defaultClient(urlval).HtableDump(context.Background(), tableval)
 }
 
 func HtableFlush(tableval string, urlval string) (bool, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "htable.flush")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.htable", tableval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	_, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return defaultClient(urlval).HtableFlush(context.Background(), tableval)
 }
 
 func HtableGet(tableval string, keyval string, urlval string) (string, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "htable.get")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.htable", tableval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.key", keyval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	getval, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return "", err
-	}
-
-	parse, err := HtableParseValueSingle(getval)
-	if err != nil {
-		return "", err
-	}
-
-	return parse, nil
+	return defaultClient(urlval).HtableGet(context.Background(), tableval, keyval)
 }
 
 // changed 2023-01-18 to treat string as int in json for seti.
 func HtableSetInt(tableval string, keyval string, valval string, urlval string) (bool, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "htable.seti")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.htable", tableval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.key", keyval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.value", valval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	_, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return defaultClient(urlval).HtableSetInt(context.Background(), tableval, keyval, valval)
 }
 
 func HtableSetString(tableval string, keyval string, valval string, urlval string) (bool, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "htable.sets")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.htable", tableval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.key", keyval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.value", valval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	_, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return defaultClient(urlval).HtableSetString(context.Background(), tableval, keyval, valval)
 }
 
 func HtableParseNameOnly(jsonval string) (string, error) {
@@ -454,43 +308,11 @@ func HtableParseValueSingle(jsonval string) (string, error) {
 }
 
 func RegDeleteAOR(aorval string, urlval string) (bool, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "ul.rm")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.table", "location")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.AOR", aorval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	_, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return defaultClient(urlval).RegDeleteAOR(context.Background(), DefaultUsrlocTable, aorval)
 }
 
 func RegGetAOR(aorval string, urlval string) (string, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "ul.lookup")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.table", "location")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "params.AOR", aorval)
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	aorresult, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return "", err
-	}
-
-	if !gjson.Valid(aorresult) {
-		return "", errors.New("invalid json")
-	}
-
-	if gjson.Get(aorresult, "error.message").Exists() {
-		errstring := gjson.Get(aorresult, "error.message")
-		return "", errors.New(errstring.String())
-	}
-
-	parsedval := gjson.Get(aorresult, "result.Contacts.#.Contact.{Address,Expires,UA}")
-	return parsedval.String(), nil
+	return defaultClient(urlval).RegGetAOR(context.Background(), DefaultUsrlocTable, aorval)
 }
 
 func RegAorParse(jsonval string) (string, error) {
@@ -563,14 +385,7 @@ func RegsFullContactInfo(jsonval string) (string, error) {
 }
 
 func RegsGet(urlval string) (string, error) {
-	sendjson := `{"jsonrpc": "2.0", "method": "ul.dump", "id":` + getId() + `}`
-	htableresult, err := SendJsonhttp(sendjson, urlval)
-
-	if err != nil {
-		return "", err
-	}
-
-	return htableresult, nil
+	return defaultClient(urlval).RegsGet(context.Background())
 }
 
 func RegsSimpleParse(jsonval string) (string, error) {
@@ -619,108 +434,24 @@ func RemoveDuplicatesUnordered(elements []string) []string {
 }
 
 func SendJsonhttp(jsonstr string, urlstr string) (string, error) {
-	var err error
-
-	// send json to url
-	sendbody := strings.NewReader(jsonstr)
-	req, err := http.NewRequest("POST", urlstr, sendbody)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header = http.Header{
-		"Content-Type": {"application/json"},
-		"Accept":       {"application/json"},
-		"User-Agent":   {"pgrtools"},
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-	curlBody, err := io.ReadAll(resp.Body)
-
-	if err != nil {
-		return "error", err
-	}
-
-	return string(curlBody), nil
+	return postJson(context.Background(), http.DefaultClient, urlstr, jsonstr, "pgrtools", "", "")
 }
 
 func SendJsonhttpTimeout(jsonstr string, urlstr string, seconds time.Duration) (string, error) {
-	var err error
-
-	// send json to url
-	sendbody := strings.NewReader(jsonstr)
-	req, err := http.NewRequest("POST", urlstr, sendbody)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header = http.Header{
-		"Content-Type": {"application/json"},
-		"Accept":       {"application/json"},
-		"User-Agent":   {"pgrtools"},
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	http.DefaultClient.Timeout = seconds * time.Second
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-	curlBody, err := io.ReadAll(resp.Body)
-
-	if err != nil {
-		return "error", err
-	}
-
-	return string(curlBody), nil
+	client := &http.Client{Timeout: seconds * time.Second}
+	return postJson(context.Background(), client, urlstr, jsonstr, "pgrtools", "", "")
 }
 
 func SendJsonhttpIgnoreCert(jsonstr string, urlstr string) (string, error) {
-	var err error
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 
 	client := &http.Client{Transport: tr, Timeout: 2 * time.Second}
-
-	// send json to url
-	sendbody := strings.NewReader(jsonstr)
-	req, err := http.NewRequest("POST", urlstr, sendbody)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header = http.Header{
-		"Content-Type": {"application/json"},
-		"Accept":       {"application/json"},
-		"User-Agent":   {"pgrtools"},
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-	curlBody, err := io.ReadAll(resp.Body)
-
-	if err != nil {
-		return "error", err
-	}
-
-	return string(curlBody), nil
+	return postJson(context.Background(), client, urlstr, jsonstr, "pgrtools", "", "")
 }
 
 func SendJsonhttpIgnoreCertTimeout(jsonstr string, urlstr string, seconds time.Duration) (string, error) {
-	var err error
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
@@ -730,32 +461,7 @@ func SendJsonhttpIgnoreCertTimeout(jsonstr string, urlstr string, seconds time.D
 		Timeout:   seconds * time.Second,
 	}
 
-	// send json to url
-	sendbody := strings.NewReader(jsonstr)
-	req, err := http.NewRequest("POST", urlstr, sendbody)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header = http.Header{
-		"Content-Type": {"application/json"},
-		"Accept":       {"application/json"},
-		"User-Agent":   {"pgrtools"},
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-	curlBody, err := io.ReadAll(resp.Body)
-
-	if err != nil {
-		return "error", err
-	}
-
-	return string(curlBody), nil
+	return postJson(context.Background(), client, urlstr, jsonstr, "pgrtools", "", "")
 }
 
 // send a get request via http and return the response
@@ -850,17 +556,7 @@ func SendGethttpIgnoreCertTimeout(urlstr string, seconds time.Duration) (string,
 }
 
 func Uptime(urlval string) (string, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "core.uptime")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	results, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return "", err
-	}
-
-	uptimeResult, _ := UptimeParse(results)
-	return uptimeResult, nil
+	return defaultClient(urlval).Uptime(context.Background())
 }
 
 func UptimeParse(jsonval string) (string, error) {
@@ -878,17 +574,7 @@ func UptimeParse(jsonval string) (string, error) {
 }
 
 func Version(urlval string) (string, error) {
-	sendJsonStr, _ := sjson.Set("", "jsonrpc", "2.0")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "method", "core.version")
-	sendJsonStr, _ = sjson.Set(sendJsonStr, "id", getId())
-	results, err := SendJsonhttp(sendJsonStr, urlval)
-
-	if err != nil {
-		return "", err
-	}
-
-	versionResult, _ := VersionParse(results)
-	return versionResult, nil
+	return defaultClient(urlval).Version(context.Background())
 }
 
 func VersionParse(jsonval string) (string, error) {
@@ -905,12 +591,6 @@ func VersionParse(jsonval string) (string, error) {
 	return parsedval.String(), nil
 }
 
-func getId() string {
-	timenow := time.Now().UnixMicro()
-	timenowstr := strconv.FormatInt(timenow, 10)
-	return timenowstr
-}
-
 func formatLastModifed(scientificNotation string) (string, error) {
 	flt, _, err := big.ParseFloat(scientificNotation, 10, 0, big.ToNearestEven)
 	if err != nil {
@@ -0,0 +1,438 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Gateway is a carrier gateway of lcr or carrierroute, in a form common to
+// both. Group is the lcr_id for lcr and "carrier/domain" for carrierroute.
+type Gateway struct {
+	Module       string    `json:"module"`
+	Group        string    `json:"group"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Host         string    `json:"host"`
+	Port         int       `json:"port,omitempty"`
+	Transport    string    `json:"transport,omitempty"`
+	Prefix       string    `json:"prefix,omitempty"`
+	Strip        int       `json:"strip,omitempty"`
+	Tag          string    `json:"tag,omitempty"`
+	Enabled      bool      `json:"enabled"`
+	Defunct      bool      `json:"defunct"`
+	DefunctUntil time.Time `json:"defunct_until,omitempty"`
+}
+
+// LCRGateway is one gateway of lcr.dump_gws.
+type LCRGateway struct {
+	LCRID        int       `json:"lcr_id"`
+	GWIndex      int       `json:"gw_index"`
+	GWID         int       `json:"gw_id"`
+	Name         string    `json:"gw_name"`
+	Scheme       string    `json:"scheme"`
+	IPAddr       string    `json:"ip_addr"`
+	Hostname     string    `json:"hostname"`
+	Port         int       `json:"port"`
+	Params       string    `json:"params"`
+	Transport    string    `json:"transport"`
+	Strip        int       `json:"strip"`
+	Prefix       string    `json:"prefix"`
+	Tag          string    `json:"tag"`
+	Flags        int       `json:"flags"`
+	State        int       `json:"state"`
+	DefunctUntil time.Time `json:"defunct_until"`
+}
+
+// LCRRule is one rule of lcr.dump_rules.
+type LCRRule struct {
+	LCRID      int         `json:"lcr_id"`
+	RuleID     int         `json:"rule_id"`
+	Prefix     string      `json:"prefix"`
+	FromURI    string      `json:"from_uri"`
+	RequestURI string      `json:"request_uri"`
+	Stopper    int         `json:"stopper"`
+	Targets    []LCRTarget `json:"targets,omitempty"`
+}
+
+// LCRTarget is a gateway of an lcr rule.
+type LCRTarget struct {
+	GWIndex  int `json:"gw_index"`
+	GWID     int `json:"gw_id"`
+	Priority int `json:"priority"`
+	Weight   int `json:"weight"`
+}
+
+// CRRoute is one route of cr.dump_routes.
+type CRRoute struct {
+	Carrier       string  `json:"carrier"`
+	Domain        string  `json:"domain"`
+	Prefix        string  `json:"prefix"`
+	Probability   float64 `json:"probability"`
+	Host          string  `json:"host"`
+	Enabled       bool    `json:"enabled"`
+	Strip         int     `json:"strip"`
+	RewritePrefix string  `json:"rewrite_prefix"`
+	RewriteSuffix string  `json:"rewrite_suffix"`
+	Comment       string  `json:"comment"`
+}
+
+type lcrGatewayJson struct {
+	LCRID        json.Number `json:"lcr_id"`
+	GWIndex      json.Number `json:"gw_index"`
+	GWID         json.Number `json:"gw_id"`
+	Name         string      `json:"gw_name"`
+	Scheme       string      `json:"scheme"`
+	IPAddr       string      `json:"ip_addr"`
+	Hostname     string      `json:"hostname"`
+	Port         json.Number `json:"port"`
+	Params       string      `json:"params"`
+	Transport    string      `json:"transport"`
+	Strip        json.Number `json:"strip"`
+	Prefix       string      `json:"prefix"`
+	Tag          string      `json:"tag"`
+	Flags        json.Number `json:"flags"`
+	State        json.Number `json:"state"`
+	DefunctUntil json.Number `json:"defunct_until"`
+}
+
+type lcrRuleJson struct {
+	LCRID      json.Number `json:"lcr_id"`
+	RuleID     json.Number `json:"rule_id"`
+	Prefix     string      `json:"prefix"`
+	FromURI    string      `json:"from_uri"`
+	RequestURI string      `json:"request_uri"`
+	Stopper    json.Number `json:"stopper"`
+}
+
+type lcrTargetJson struct {
+	GWIndex  json.Number `json:"gw_index"`
+	GWID     json.Number `json:"gw_id"`
+	Priority json.Number `json:"priority"`
+	Weight   json.Number `json:"weight"`
+}
+
+func (c *Client) DroutingReload(ctx context.Context) error {
+	return c.Call(ctx, "drouting.reload", nil, nil)
+}
+
+func (c *Client) LCRReload(ctx context.Context) error {
+	return c.Call(ctx, "lcr.reload", nil, nil)
+}
+
+// LCRGateways returns the gateways of lcr.dump_gws.
+func (c *Client) LCRGateways(ctx context.Context) ([]LCRGateway, error) {
+	rows, err := callList[json.RawMessage](ctx, c, "lcr.dump_gws", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var gateways []LCRGateway
+	for _, row := range rows {
+		list, err := decodeRepeated[lcrGatewayJson](row, "gw")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, gw := range list {
+			gateways = append(gateways, LCRGateway{
+				LCRID:        int(numberInt(gw.LCRID)),
+				GWIndex:      int(numberInt(gw.GWIndex)),
+				GWID:         int(numberInt(gw.GWID)),
+				Name:         gw.Name,
+				Scheme:       gw.Scheme,
+				IPAddr:       gw.IPAddr,
+				Hostname:     gw.Hostname,
+				Port:         int(numberInt(gw.Port)),
+				Params:       gw.Params,
+				Transport:    gw.Transport,
+				Strip:        int(numberInt(gw.Strip)),
+				Prefix:       gw.Prefix,
+				Tag:          gw.Tag,
+				Flags:        int(numberInt(gw.Flags)),
+				State:        int(numberInt(gw.State)),
+				DefunctUntil: unixTime(numberInt(gw.DefunctUntil)),
+			})
+		}
+	}
+
+	return gateways, nil
+}
+
+// LCRRules returns the rules of lcr.dump_rules with their gateways.
+func (c *Client) LCRRules(ctx context.Context) ([]LCRRule, error) {
+	rows, err := callList[json.RawMessage](ctx, c, "lcr.dump_rules", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []LCRRule
+	for _, row := range rows {
+		list, err := decodeRepeated[json.RawMessage](row, "rule")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, raw := range list {
+			rule, err := decodeLCRRule(raw)
+			if err != nil {
+				return nil, err
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+// decodeLCRRule decodes a rule, taking every nested struct with a gateway
+// as one of its targets.
+func decodeLCRRule(raw json.RawMessage) (LCRRule, error) {
+	var r lcrRuleJson
+	if err := decodeResult(raw, &r); err != nil {
+		return LCRRule{}, err
+	}
+
+	rule := LCRRule{
+		LCRID:      int(numberInt(r.LCRID)),
+		RuleID:     int(numberInt(r.RuleID)),
+		Prefix:     r.Prefix,
+		FromURI:    r.FromURI,
+		RequestURI: r.RequestURI,
+		Stopper:    int(numberInt(r.Stopper)),
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return rule, err
+	}
+
+	for decoder.More() {
+		if _, err := decoder.Token(); err != nil {
+			return rule, invalidResponse(err)
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return rule, invalidResponse(err)
+		}
+
+		value = bytes.TrimSpace(value)
+		if len(value) == 0 || (value[0] != '{' && value[0] != '[') {
+			continue
+		}
+
+		targets, err := decodeList[lcrTargetJson](value)
+		if err != nil {
+			continue
+		}
+
+		for _, t := range targets {
+			if t.GWIndex == "" && t.GWID == "" {
+				continue
+			}
+
+			rule.Targets = append(rule.Targets, LCRTarget{
+				GWIndex:  int(numberInt(t.GWIndex)),
+				GWID:     int(numberInt(t.GWID)),
+				Priority: int(numberInt(t.Priority)),
+				Weight:   int(numberInt(t.Weight)),
+			})
+		}
+	}
+
+	return rule, nil
+}
+
+// LCRDefunctGateway sets a gateway defunct for period (lcr.defunct_gw).
+func (c *Client) LCRDefunctGateway(ctx context.Context, lcrID int, gwID int, period time.Duration) error {
+	return c.Call(ctx, "lcr.defunct_gw", []any{lcrID, gwID, int(period / time.Second)}, nil)
+}
+
+func (c *Client) CRReloadRoutes(ctx context.Context) error {
+	return c.Call(ctx, "cr.reload_routes", nil, nil)
+}
+
+// CRDumpRoutes returns the routes of cr.dump_routes.
+func (c *Client) CRDumpRoutes(ctx context.Context) ([]CRRoute, error) {
+	lines, err := callList[string](ctx, c, "cr.dump_routes", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCRRoutes(lines), nil
+}
+
+var (
+	crCarrierLine = regexp.MustCompile(`^Printing tree for carrier '([^']*)'`)
+	crDomainLine  = regexp.MustCompile(`^Printing tree for domain '([^']*)'`)
+	crRouteLine   = regexp.MustCompile(`^\s*(\S+):\s*([0-9.]+)\s*%,\s*'([^']*)':\s*(ON|OFF),\s*'(-?\d+)',\s*'([^']*)',\s*'([^']*)',\s*'([^']*)'`)
+)
+
+// ParseCRRoutes parses the text lines of cr.dump_routes. A "NULL" prefix is
+// the empty prefix matching any number.
+func ParseCRRoutes(lines []string) []CRRoute {
+	var routes []CRRoute
+	carrier, domain := "", ""
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if m := crCarrierLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			carrier = m[1]
+			continue
+		}
+
+		if m := crDomainLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			domain = m[1]
+			continue
+		}
+
+		m := crRouteLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		probability, _ := strconv.ParseFloat(m[2], 64)
+		strip, _ := strconv.Atoi(m[5])
+		route := CRRoute{
+			Carrier:       carrier,
+			Domain:        domain,
+			Prefix:        m[1],
+			Probability:   probability,
+			Host:          m[3],
+			Enabled:       m[4] == "ON",
+			Strip:         strip,
+			RewritePrefix: crNull(m[6]),
+			RewriteSuffix: crNull(m[7]),
+			Comment:       crNull(m[8]),
+		}
+
+		if route.Prefix == "NULL" {
+			route.Prefix = ""
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+func crNull(value string) string {
+	if value == "null" || value == "NULL" {
+		return ""
+	}
+
+	return value
+}
+
+// Gateway converts to the common Gateway type.
+func (g LCRGateway) Gateway() Gateway {
+	host := g.Hostname
+	if host == "" {
+		host = g.IPAddr
+	}
+
+	return Gateway{
+		Module:       "lcr",
+		Group:        strconv.Itoa(g.LCRID),
+		ID:           strconv.Itoa(g.GWID),
+		Name:         g.Name,
+		Host:         host,
+		Port:         g.Port,
+		Transport:    g.Transport,
+		Prefix:       g.Prefix,
+		Strip:        g.Strip,
+		Tag:          g.Tag,
+		Enabled:      true,
+		Defunct:      g.DefunctUntil.After(time.Now()),
+		DefunctUntil: g.DefunctUntil,
+	}
+}
+
+// Gateway converts to the common Gateway type. A route with status OFF is
+// reported as defunct.
+func (r CRRoute) Gateway() Gateway {
+	gw := Gateway{
+		Module:  "carrierroute",
+		Group:   r.Carrier + "/" + r.Domain,
+		ID:      strings.TrimSpace(r.Prefix + " " + r.Host),
+		Name:    r.Comment,
+		Host:    r.Host,
+		Prefix:  r.RewritePrefix,
+		Strip:   r.Strip,
+		Enabled: r.Enabled,
+		Defunct: !r.Enabled,
+	}
+
+	if sip, err := ParseSIPURI(r.Host); err == nil && sip.Host != "" {
+		gw.Host = sip.Host
+		gw.Port = sip.Port
+	} else if host, port, err := splitHostPort(r.Host); err == nil {
+		gw.Host = host
+		gw.Port = port
+	}
+
+	return gw
+}
+
+// Gateways returns the gateways of the routing module loaded on the node,
+// lcr or carrierroute. Modules that are not loaded are skipped; an error is
+// returned only when neither answers.
+func (c *Client) Gateways(ctx context.Context) ([]Gateway, error) {
+	var gateways []Gateway
+	var rpcErr *RPCError
+
+	lcr, lcrErr := c.LCRGateways(ctx)
+	if lcrErr != nil && !errors.As(lcrErr, &rpcErr) {
+		return nil, lcrErr
+	}
+
+	for _, gw := range lcr {
+		gateways = append(gateways, gw.Gateway())
+	}
+
+	routes, crErr := c.CRDumpRoutes(ctx)
+	if crErr != nil && !errors.As(crErr, &rpcErr) {
+		return nil, crErr
+	}
+
+	for _, route := range routes {
+		gateways = append(gateways, route.Gateway())
+	}
+
+	if lcrErr != nil && crErr != nil {
+		return nil, errors.New("no lcr or carrierroute on node: " + lcrErr.Error() + ", " + crErr.Error())
+	}
+
+	return gateways, nil
+}
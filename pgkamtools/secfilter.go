@@ -0,0 +1,310 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// secfilter list types.
+const (
+	SecfilterUserAgent = "ua"
+	SecfilterCountry   = "country"
+	SecfilterDomain    = "domain"
+	SecfilterIP        = "ip"
+	SecfilterUser      = "user"
+)
+
+// SecfilterEntry is one value of secfilter.print. List is "blacklist",
+// "whitelist" or "destination"; Type one of the Secfilter* types when known.
+type SecfilterEntry struct {
+	List    string `json:"list"`
+	Type    string `json:"type,omitempty"`
+	Value   string `json:"value"`
+	Section string `json:"section"`
+}
+
+// SecfilterStat is one counter of secfilter.stats.
+type SecfilterStat struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Value   int64  `json:"value"`
+}
+
+// PikeNode is one source of pike.top.
+type PikeNode struct {
+	IP       string `json:"ip_addr"`
+	HitsPrev int    `json:"leaf_hits_prev"`
+	HitsCurr int    `json:"leaf_hits_curr"`
+	Expires  int    `json:"expires"`
+	Status   string `json:"status"`
+}
+
+func (c *Client) SecfilterAddBlacklist(ctx context.Context, listType string, value string) error {
+	return c.Call(ctx, "secfilter.add_bl", []any{listType, value}, nil)
+}
+
+func (c *Client) SecfilterAddWhitelist(ctx context.Context, listType string, value string) error {
+	return c.Call(ctx, "secfilter.add_wl", []any{listType, value}, nil)
+}
+
+func (c *Client) SecfilterDelBlacklist(ctx context.Context, listType string, value string) error {
+	return c.Call(ctx, "secfilter.del_bl", []any{listType, value}, nil)
+}
+
+func (c *Client) SecfilterDelWhitelist(ctx context.Context, listType string, value string) error {
+	return c.Call(ctx, "secfilter.del_wl", []any{listType, value}, nil)
+}
+
+// SecfilterPrint returns the blacklisted and whitelisted values, only those
+// of listType if not empty (secfilter.print).
+func (c *Client) SecfilterPrint(ctx context.Context, listType string) ([]SecfilterEntry, error) {
+	var params []any
+	if listType != "" {
+		params = append(params, listType)
+	}
+
+	lines, err := callList[string](ctx, c, "secfilter.print", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecfilterPrint(lines), nil
+}
+
+// SecfilterStats returns the counters of secfilter.stats.
+func (c *Client) SecfilterStats(ctx context.Context) ([]SecfilterStat, error) {
+	lines, err := callList[string](ctx, c, "secfilter.stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecfilterStats(lines), nil
+}
+
+// ParseSecfilterPrint parses the text lines of secfilter.print: a title per
+// list type ("User-agent", "IP address", ...), "[+] Blacklisted" and
+// "[+] Whitelisted" lines, each followed by its indented values.
+func ParseSecfilterPrint(lines []string) []SecfilterEntry {
+	var entries []SecfilterEntry
+	section, list, listType := "", "", ""
+	for _, line := range lines {
+		kind, text := secfilterLine(line)
+		switch kind {
+		case secfilterTitle:
+			section = text
+			list, listType = secfilterSection(text)
+		case secfilterItem:
+			if itemList, _ := secfilterSection(text); itemList != "" && list != "destination" {
+				list = itemList
+			}
+		case secfilterValue:
+			entries = append(entries, SecfilterEntry{
+				List:    list,
+				Type:    listType,
+				Value:   text,
+				Section: section,
+			})
+		}
+	}
+
+	return entries
+}
+
+// ParseSecfilterStats parses the text lines of secfilter.stats: a title per
+// list followed by "[+] name: count" lines.
+func ParseSecfilterStats(lines []string) []SecfilterStat {
+	var stats []SecfilterStat
+	section := ""
+	for _, line := range lines {
+		kind, text := secfilterLine(line)
+		if kind == secfilterTitle {
+			section = text
+			continue
+		}
+
+		if kind != secfilterItem {
+			continue
+		}
+
+		name, value, found := strings.Cut(text, ":")
+		number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if !found || err != nil {
+			continue
+		}
+
+		stats = append(stats, SecfilterStat{
+			Section: section,
+			Name:    strings.TrimSpace(name),
+			Value:   number,
+		})
+	}
+
+	return stats
+}
+
+type secfilterLineKind int
+
+const (
+	secfilterSkip secfilterLineKind = iota
+	secfilterTitle
+	secfilterItem
+	secfilterValue
+)
+
+// secfilterLine classifies a line of secfilter.print or secfilter.stats and
+// returns its text. Titles start at the first column and are underlined,
+// items start with "[+]" and values are indented.
+func secfilterLine(line string) (secfilterLineKind, string) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "" || strings.Trim(trimmed, "=-") == "":
+		return secfilterSkip, ""
+	case strings.HasPrefix(trimmed, "[+]"):
+		return secfilterItem, strings.TrimSpace(trimmed[len("[+]"):])
+	case strings.TrimLeft(line, " \t") != line:
+		return secfilterValue, trimmed
+	}
+
+	return secfilterTitle, trimmed
+}
+
+func secfilterSection(section string) (string, string) {
+	lower := strings.ToLower(section)
+	list := ""
+	switch {
+	case strings.Contains(lower, "black"):
+		list = "blacklist"
+	case strings.Contains(lower, "white"):
+		list = "whitelist"
+	case strings.Contains(lower, "destination"):
+		list = "destination"
+	}
+
+	words := strings.FieldsFunc(lower, func(r rune) bool {
+		return r < 'a' || r > 'z'
+	})
+
+	for _, word := range words {
+		switch {
+		case word == "ua" || word == "agent" || word == "agents":
+			return list, SecfilterUserAgent
+		case strings.HasPrefix(word, "countr"):
+			return list, SecfilterCountry
+		case strings.HasPrefix(word, "domain"):
+			return list, SecfilterDomain
+		case word == "ip" || word == "ips" || strings.HasPrefix(word, "address"):
+			return list, SecfilterIP
+		}
+	}
+
+	for _, word := range words {
+		if word == "user" || word == "users" {
+			return list, SecfilterUser
+		}
+	}
+
+	return list, ""
+}
+
+// PikeTop returns the sources tracked by pike, filter is "HOT", "WARM" or
+// "ALL" (pike.top).
+func (c *Client) PikeTop(ctx context.Context, filter string) ([]PikeNode, error) {
+	if filter == "" {
+		filter = "ALL"
+	}
+
+	var raw json.RawMessage
+	if err := c.Call(ctx, "pike.top", []any{filter}, &raw); err != nil {
+		return nil, err
+	}
+
+	return parsePikeTop(raw)
+}
+
+// parsePikeTop decodes the single struct of pike.top, where the member names
+// carry the row index (ip_addr0, leaf_hits_prev0, ..., ip_addr1, ...)
+// followed by number_of_rows.
+func parsePikeTop(raw json.RawMessage) ([]PikeNode, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return nil, nil
+	}
+
+	var members map[string]json.RawMessage
+	if err := decodeResult(raw, &members); err != nil {
+		return nil, err
+	}
+
+	rows := -1
+	if value, ok := members["number_of_rows"]; ok {
+		if err := decodeResult(value, &rows); err != nil {
+			return nil, err
+		}
+	}
+
+	var nodes []PikeNode
+	for i := 0; rows < 0 || i < rows; i++ {
+		index := strconv.Itoa(i)
+		if _, ok := members["ip_addr"+index]; !ok {
+			if rows < 0 {
+				break
+			}
+
+			continue
+		}
+
+		var node PikeNode
+		fields := []struct {
+			name  string
+			value any
+		}{
+			{"ip_addr", &node.IP},
+			{"leaf_hits_prev", &node.HitsPrev},
+			{"leaf_hits_curr", &node.HitsCurr},
+			{"expires", &node.Expires},
+			{"status", &node.Status},
+		}
+
+		for _, field := range fields {
+			if err := decodeResult(members[field.name+index], field.value); err != nil {
+				return nil, err
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// Hot reports whether pike is blocking the source.
+func (n PikeNode) Hot() bool {
+	return strings.EqualFold(n.Status, "HOT")
+}
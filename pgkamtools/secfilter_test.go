@@ -0,0 +1,223 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveResult answers every jsonrpc request with result.
+func serveResult(t *testing.T, result string) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]json.RawMessage
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid request %q: %v", body, err)
+		}
+
+		io.WriteString(w, `{"jsonrpc":"2.0","id":`+string(req["id"])+`,"result":`+result+`}`)
+	}))
+
+	t.Cleanup(srv.Close)
+	c, err := NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestPikeTop(t *testing.T) {
+	// kamcmd pike.top ALL, as sent by jsonrpcs
+	c := serveResult(t, `{
+		"ip_addr0": "192.0.2.10",
+		"leaf_hits_prev0": 12,
+		"leaf_hits_curr0": 31,
+		"expires0": 118,
+		"status0": "HOT",
+		"ip_addr1": "2001:db8::7",
+		"leaf_hits_prev1": 0,
+		"leaf_hits_curr1": 2,
+		"expires1": 94,
+		"status1": "WARM",
+		"number_of_rows": 2
+	}`)
+
+	nodes, err := c.PikeTop(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PikeNode{
+		{IP: "192.0.2.10", HitsPrev: 12, HitsCurr: 31, Expires: 118, Status: "HOT"},
+		{IP: "2001:db8::7", HitsPrev: 0, HitsCurr: 2, Expires: 94, Status: "WARM"},
+	}
+
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v, want %+v", nodes, want)
+	}
+
+	if !nodes[0].Hot() || nodes[1].Hot() {
+		t.Error("Hot() doesn't match the status")
+	}
+}
+
+func TestPikeTopEmpty(t *testing.T) {
+	c := serveResult(t, `{"number_of_rows": 0}`)
+	nodes, err := c.PikeTop(context.Background(), "HOT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 0 {
+		t.Fatalf("got %+v, want no nodes", nodes)
+	}
+}
+
+// kamcmd secfilter.print
+const secfilterPrintOutput = `
+Destinations
+============
+[+] Blacklisted
+    -----------
+    900
+    0034600111222
+
+User-agent
+==========
+[+] Blacklisted
+    -----------
+    friendly-scanner
+    sipcli
+[+] Whitelisted
+    -----------
+    Linphone
+
+Country
+=======
+[+] Blacklisted
+    -----------
+    CN
+[+] Whitelisted
+    -----------
+
+Domain
+======
+[+] Blacklisted
+    -----------
+    example.net
+[+] Whitelisted
+    -----------
+    example.com
+
+IP address
+==========
+[+] Blacklisted
+    -----------
+    192.0.2.66
+[+] Whitelisted
+    -----------
+    10.0.0.0/8
+
+User
+====
+[+] Blacklisted
+    -----------
+    admin
+[+] Whitelisted
+    -----------
+`
+
+// kamcmd secfilter.stats
+const secfilterStatsOutput = `
+Blacklist
+=========
+[+] By user-agent: 4
+[+] By country: 1
+[+] By from domain: 0
+[+] By IP address: 7
+
+Whitelist
+=========
+[+] By user-agent: 2
+[+] By IP address: 0
+
+Destination
+===========
+[+] Calls to blacklisted destinations: 3
+
+SQL injection
+=============
+[+] Found: 0
+`
+
+func TestParseSecfilterPrint(t *testing.T) {
+	entries := ParseSecfilterPrint(strings.Split(secfilterPrintOutput, "\n"))
+	want := []SecfilterEntry{
+		{List: "destination", Value: "900", Section: "Destinations"},
+		{List: "destination", Value: "0034600111222", Section: "Destinations"},
+		{List: "blacklist", Type: SecfilterUserAgent, Value: "friendly-scanner", Section: "User-agent"},
+		{List: "blacklist", Type: SecfilterUserAgent, Value: "sipcli", Section: "User-agent"},
+		{List: "whitelist", Type: SecfilterUserAgent, Value: "Linphone", Section: "User-agent"},
+		{List: "blacklist", Type: SecfilterCountry, Value: "CN", Section: "Country"},
+		{List: "blacklist", Type: SecfilterDomain, Value: "example.net", Section: "Domain"},
+		{List: "whitelist", Type: SecfilterDomain, Value: "example.com", Section: "Domain"},
+		{List: "blacklist", Type: SecfilterIP, Value: "192.0.2.66", Section: "IP address"},
+		{List: "whitelist", Type: SecfilterIP, Value: "10.0.0.0/8", Section: "IP address"},
+		{List: "blacklist", Type: SecfilterUser, Value: "admin", Section: "User"},
+	}
+
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("got %+v\nwant %+v", entries, want)
+	}
+}
+
+func TestParseSecfilterStats(t *testing.T) {
+	stats := ParseSecfilterStats(strings.Split(secfilterStatsOutput, "\n"))
+	want := []SecfilterStat{
+		{Section: "Blacklist", Name: "By user-agent", Value: 4},
+		{Section: "Blacklist", Name: "By country", Value: 1},
+		{Section: "Blacklist", Name: "By from domain", Value: 0},
+		{Section: "Blacklist", Name: "By IP address", Value: 7},
+		{Section: "Whitelist", Name: "By user-agent", Value: 2},
+		{Section: "Whitelist", Name: "By IP address", Value: 0},
+		{Section: "Destination", Name: "Calls to blacklisted destinations", Value: 3},
+		{Section: "SQL injection", Name: "Found", Value: 0},
+	}
+
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("got %+v\nwant %+v", stats, want)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SIPURI is a parsed sip or sips uri, such as a usrloc received address or
+// path entry. Raw keeps the original value.
+type SIPURI struct {
+	Raw    string            `json:"raw"`
+	Scheme string            `json:"scheme"`
+	User   string            `json:"user,omitempty"`
+	Host   string            `json:"host"`
+	Port   int               `json:"port,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// SocketAddr is a kamailio socket such as udp:10.0.0.1:5060.
+type SocketAddr struct {
+	Proto string `json:"proto"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+}
+
+// ParseSIPURI parses sip:user@host:port;param=value. Angle brackets and a
+// display name are ignored. Headers (?...) are dropped.
+func ParseSIPURI(value string) (SIPURI, error) {
+	uri := SIPURI{Raw: value}
+	s := strings.TrimSpace(value)
+	if start := strings.Index(s, "<"); start >= 0 {
+		end := strings.Index(s[start:], ">")
+		if end < 0 {
+			return uri, errors.New("invalid sip uri " + value)
+		}
+
+		s = s[start+1 : start+end]
+	}
+
+	colon := strings.Index(s, ":")
+	if colon < 0 {
+		return uri, errors.New("invalid sip uri " + value)
+	}
+
+	uri.Scheme = strings.ToLower(s[:colon])
+	if uri.Scheme != "sip" && uri.Scheme != "sips" && uri.Scheme != "tel" {
+		return uri, errors.New("invalid sip uri scheme " + value)
+	}
+
+	s = s[colon+1:]
+	if i := strings.Index(s, "?"); i >= 0 {
+		s = s[:i]
+	}
+
+	params := ""
+	if i := strings.Index(s, ";"); i >= 0 {
+		params = s[i+1:]
+		s = s[:i]
+	}
+
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		uri.User = s[:i]
+		s = s[i+1:]
+	}
+
+	host, port, err := splitHostPort(s)
+	if err != nil {
+		return uri, errors.New("invalid sip uri port " + value)
+	}
+
+	uri.Host = host
+	uri.Port = port
+	if params != "" {
+		uri.Params = map[string]string{}
+		for _, param := range strings.Split(params, ";") {
+			if param == "" {
+				continue
+			}
+
+			name, val, _ := strings.Cut(param, "=")
+			uri.Params[strings.ToLower(name)] = val
+		}
+	}
+
+	return uri, nil
+}
+
+// ParseSIPURIList parses a comma separated list of uris, like a Path.
+func ParseSIPURIList(value string) ([]SIPURI, error) {
+	var uris []SIPURI
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		uri, err := ParseSIPURI(part)
+		if err != nil {
+			return nil, err
+		}
+
+		uris = append(uris, uri)
+	}
+
+	return uris, nil
+}
+
+// ParseSocket parses proto:host:port. The port may be left out.
+func ParseSocket(value string) (SocketAddr, error) {
+	var socket SocketAddr
+	proto, rest, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok {
+		return socket, errors.New("invalid socket " + value)
+	}
+
+	host, port, err := splitHostPort(rest)
+	if err != nil {
+		return socket, errors.New("invalid socket port " + value)
+	}
+
+	socket.Proto = strings.ToLower(proto)
+	socket.Host = host
+	socket.Port = port
+	return socket, nil
+}
+
+func (s SocketAddr) String() string {
+	if s.Port == 0 {
+		return s.Proto + ":" + s.Host
+	}
+
+	return s.Proto + ":" + joinHostPort(s.Host, s.Port)
+}
+
+func (u SIPURI) String() string {
+	return u.Raw
+}
+
+// splitHostPort handles ipv6 references ([::1]:5060) and a missing port.
+func splitHostPort(s string) (string, int, error) {
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return "", 0, errors.New("invalid ipv6 reference")
+		}
+
+		host := s[1:end]
+		rest := s[end+1:]
+		if rest == "" {
+			return host, 0, nil
+		}
+
+		if !strings.HasPrefix(rest, ":") {
+			return "", 0, errors.New("invalid port")
+		}
+
+		port, err := strconv.Atoi(rest[1:])
+		return host, port, err
+	}
+
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return s, 0, nil
+	}
+
+	port, err := strconv.Atoi(s[i+1:])
+	return s[:i], port, err
+}
+
+func joinHostPort(host string, port int) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return host + ":" + strconv.Itoa(port)
+}
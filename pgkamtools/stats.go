@@ -0,0 +1,165 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Statistic is one line of stats.get_statistics, "group:name = value".
+type Statistic struct {
+	Group string `json:"group"`
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type Statistics []Statistic
+
+// ParseStatistic parses a single "group:name = value" line.
+func ParseStatistic(line string) (Statistic, error) {
+	key, value, found := strings.Cut(line, "=")
+	if !found {
+		return Statistic{}, errors.New("invalid statistic: " + line)
+	}
+
+	group, name, found := strings.Cut(strings.TrimSpace(key), ":")
+	if !found || group == "" || name == "" {
+		return Statistic{}, errors.New("invalid statistic name: " + line)
+	}
+
+	number, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return Statistic{}, errors.New("invalid statistic value: " + line)
+	}
+
+	return Statistic{Group: group, Name: name, Value: number}, nil
+}
+
+// ParseStatistics parses the lines of stats.get_statistics. Empty lines are
+// skipped.
+func ParseStatistics(lines []string) (Statistics, error) {
+	stats := make(Statistics, 0, len(lines))
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		stat, err := ParseStatistic(line)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, stat)
+	}
+
+	return stats, nil
+}
+
+// Get returns the value of group:name.
+func (s Statistics) Get(group string, name string) (uint64, bool) {
+	for _, stat := range s {
+		if stat.Group == group && stat.Name == name {
+			return stat.Value, true
+		}
+	}
+
+	return 0, false
+}
+
+// Group returns the statistics of one group.
+func (s Statistics) Group(group string) Statistics {
+	var found Statistics
+	for _, stat := range s {
+		if stat.Group == group {
+			found = append(found, stat)
+		}
+	}
+
+	return found
+}
+
+// Map returns the statistics keyed by "group:name".
+func (s Statistics) Map() map[string]uint64 {
+	values := make(map[string]uint64, len(s))
+	for _, stat := range s {
+		values[stat.Group+":"+stat.Name] = stat.Value
+	}
+
+	return values
+}
+
+func (s Statistics) sort() {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Group != s[j].Group {
+			return s[i].Group < s[j].Group
+		}
+
+		return s[i].Name < s[j].Name
+	})
+}
+
+func statParams(names []string) []any {
+	if len(names) == 0 {
+		return []any{"all"}
+	}
+
+	params := make([]any, 0, len(names))
+	for _, name := range names {
+		params = append(params, name)
+	}
+
+	return params
+}
+
+func (c *Client) callStatistics(ctx context.Context, method string, names []string) (Statistics, error) {
+	lines, err := callList[string](ctx, c, method, statParams(names))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseStatistics(lines)
+}
+
+// Statistics returns the statistics for names, which can be "group:name",
+// "group:" or "all". No names means all.
+func (c *Client) Statistics(ctx context.Context, names ...string) (Statistics, error) {
+	return c.callStatistics(ctx, "stats.get_statistics", names)
+}
+
+// StatisticsReset resets the statistics for names (all when none given).
+func (c *Client) StatisticsReset(ctx context.Context, names ...string) error {
+	return c.Call(ctx, "stats.reset_statistics", statParams(names), nil)
+}
+
+// StatisticsClear resets the statistics for names (all when none given) and
+// returns their values from before the reset.
+func (c *Client) StatisticsClear(ctx context.Context, names ...string) (Statistics, error) {
+	return c.callStatistics(ctx, "stats.clear_statistics", names)
+}
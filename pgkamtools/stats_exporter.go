@@ -0,0 +1,204 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// StatsExporter is an http.Handler that serves the statistics, shared and
+// private memory of a Kamailio node in the Prometheus text format. The node is taken
+// from the target query parameter of each scrape (ie
+// /metrics?target=http://10.0.0.10/RPC), or Target when there is none.
+type StatsExporter struct {
+	// Target is used when a scrape has no target parameter.
+	Target string
+	// Targets lists the other urls a scrape may ask for. When empty only
+	// Target can be scraped.
+	Targets []string
+	// Namespace prefixes every metric, default "kamailio".
+	Namespace string
+	// Options are passed to NewClient for every target.
+	Options []Option
+
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+func NewStatsExporter(target string, opts ...Option) *StatsExporter {
+	return &StatsExporter{
+		Target:  target,
+		Options: opts,
+	}
+}
+
+func (e *StatsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = e.Target
+	}
+
+	if target == "" {
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return
+	}
+
+	if !e.allowed(target) {
+		http.Error(w, "target not allowed", http.StatusForbidden)
+		return
+	}
+
+	client, err := e.client(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(e.collect(r.Context(), client))
+}
+
+func (e *StatsExporter) allowed(target string) bool {
+	if target == e.Target {
+		return true
+	}
+
+	for _, allowed := range e.Targets {
+		if allowed == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// client returns the cached Client of target so connections are reused
+// between scrapes. Only allowed targets get here, which bounds the cache.
+func (e *StatsExporter) client(target string) (*Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if client, ok := e.clients[target]; ok {
+		return client, nil
+	}
+
+	client, err := NewClient(target, e.Options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.clients == nil {
+		e.clients = map[string]*Client{}
+	}
+
+	e.clients[target] = client
+	return client, nil
+}
+
+func (e *StatsExporter) collect(ctx context.Context, client *Client) []byte {
+	namespace := e.Namespace
+	if namespace == "" {
+		namespace = "kamailio"
+	}
+
+	m := &metricWriter{namespace: namespace, seen: map[string]bool{}}
+
+	stats, err := client.Statistics(ctx)
+	if err != nil {
+		m.write("up", "gauge", "Whether the kamailio node answered.", 0)
+		return m.buf.Bytes()
+	}
+
+	m.write("up", "gauge", "Whether the kamailio node answered.", 1)
+
+	if shm, err := client.ShmMem(ctx); err == nil {
+		m.write("shm_total_bytes", "gauge", "Total shared memory.", shm.Total)
+		m.write("shm_free_bytes", "gauge", "Free shared memory.", shm.Free)
+		m.write("shm_used_bytes", "gauge", "Used shared memory.", shm.Used)
+		m.write("shm_real_used_bytes", "gauge", "Used shared memory including overhead.", shm.RealUsed)
+		m.write("shm_max_used_bytes", "gauge", "Maximum used shared memory.", shm.MaxUsed)
+		m.write("shm_fragments", "gauge", "Shared memory fragments.", shm.Fragments)
+	}
+
+	if pkgs, err := client.PkgStats(ctx); err == nil {
+		var pkg PkgStat
+		for _, p := range pkgs {
+			pkg.Used += p.Used
+			pkg.Free += p.Free
+			pkg.RealUsed += p.RealUsed
+			pkg.TotalSize += p.TotalSize
+		}
+
+		m.write("pkg_total_bytes", "gauge", "Total private memory of all processes.", pkg.TotalSize)
+		m.write("pkg_free_bytes", "gauge", "Free private memory of all processes.", pkg.Free)
+		m.write("pkg_used_bytes", "gauge", "Used private memory of all processes.", pkg.Used)
+		m.write("pkg_real_used_bytes", "gauge", "Used private memory of all processes including overhead.", pkg.RealUsed)
+	}
+
+	stats.sort()
+	for _, stat := range stats {
+		m.write(stat.Group+"_"+stat.Name, "untyped", "", stat.Value)
+	}
+
+	return m.buf.Bytes()
+}
+
+type metricWriter struct {
+	namespace string
+	buf       bytes.Buffer
+	seen      map[string]bool
+}
+
+// write adds one metric, skipping names already written.
+func (m *metricWriter) write(name string, kind string, help string, value uint64) {
+	name = metricName(m.namespace + "_" + name)
+	if m.seen[name] {
+		return
+	}
+
+	m.seen[name] = true
+	if help != "" {
+		fmt.Fprintf(&m.buf, "# HELP %s %s\n", name, help)
+	}
+
+	fmt.Fprintf(&m.buf, "# TYPE %s %s\n%s %d\n", name, kind, name, value)
+}
+
+// metricName replaces the characters prometheus doesn't allow with _.
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
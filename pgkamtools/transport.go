@@ -0,0 +1,169 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// maxDatagram is the largest reply read from the jsonrpcs datagram socket.
+const maxDatagram = 1 << 20
+
+// Transport sends a raw json request to Kamailio and returns the raw
+// response. NewClient picks one from the url scheme; WithRPCTransport can
+// set a custom one.
+type Transport interface {
+	RoundTrip(ctx context.Context, request []byte) ([]byte, error)
+}
+
+// ErrBatchUnsupported is returned by transports that cannot send batch
+// arrays. Batch.Send falls back to single calls when it sees it.
+var ErrBatchUnsupported = errors.New("batch requests are not supported by this transport")
+
+// StreamTransport is implemented by transports that can hand over the
+// response without reading it into memory first.
+type StreamTransport interface {
+	RoundTripStream(ctx context.Context, request []byte) (io.ReadCloser, error)
+}
+
+type httpTransport struct {
+	client    *http.Client
+	url       string
+	userAgent string
+	username  string
+	password  string
+}
+
+func (t *httpTransport) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	resp, err := postJson(ctx, t.client, t.url, string(request), t.userAgent, t.username, t.password)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(resp), nil
+}
+
+func (t *httpTransport) RoundTripStream(ctx context.Context, request []byte) (io.ReadCloser, error) {
+	return postJsonStream(ctx, t.client, t.url, string(request), t.userAgent, t.username, t.password)
+}
+
+// unixTransport talks to the jsonrpcs datagram socket (transport 4). Kamailio
+// replies to the address of the sender, so each call binds its own socket.
+type unixTransport struct {
+	path     string
+	replyDir string
+	timeout  time.Duration
+}
+
+func (t *unixTransport) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	local := filepath.Join(t.replyDir, replyName("sock"))
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(local)
+	defer conn.Close()
+
+	// kamailio usually runs as its own user and must be able to reply.
+	if err := os.Chmod(local, 0666); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	_, err = conn.WriteToUnix(request, &net.UnixAddr{Name: t.path, Net: "unixgram"})
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	buf := make([]byte, maxDatagram)
+	n, _, err := conn.ReadFromUnix(buf)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	return buf[:n], nil
+}
+
+// roundTripStream uses RoundTripStream when the transport has it.
+func roundTripStream(ctx context.Context, transport Transport, request []byte) (io.ReadCloser, error) {
+	if stream, ok := transport.(StreamTransport); ok {
+		return stream.RoundTripStream(ctx, request)
+	}
+
+	resp, err := transport.RoundTrip(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(resp)), nil
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// ctxErr prefers the context error over the i/o error it caused.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func replyName(ext string) string {
+	return "pgkamtools_" + strconv.Itoa(os.Getpid()) + "_" + strconv.FormatInt(nextId(), 10) + "." + ext
+}
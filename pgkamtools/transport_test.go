@@ -0,0 +1,156 @@
+//go:build !windows
+
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveUnixgram answers jsonrpcs datagrams on path with the result of
+// handle, until the test ends.
+func serveUnixgram(t *testing.T, path string, handle func(req map[string]any, from *net.UnixAddr) any) {
+	t.Helper()
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := conn.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+
+			var req map[string]any
+			if err := json.Unmarshal(buf[:n], &req); err != nil {
+				t.Errorf("invalid request %q: %v", buf[:n], err)
+				continue
+			}
+
+			result := handle(req, from)
+			if result == nil {
+				continue
+			}
+
+			resp, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": req["id"], "result": result})
+			conn.WriteToUnix(resp, from)
+		}
+	}()
+}
+
+func TestUnixTransport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kamailio_rpc.sock")
+	modes := make(chan os.FileMode, 1)
+	serveUnixgram(t, path, func(req map[string]any, from *net.UnixAddr) any {
+		if info, err := os.Stat(from.Name); err == nil {
+			select {
+			case modes <- info.Mode().Perm():
+			default:
+			}
+		}
+
+		// echo the first param so each caller can check it got its own reply
+		params, _ := req["params"].([]any)
+		if len(params) == 0 {
+			return "none"
+		}
+
+		return params[0]
+	})
+
+	c, err := NewClient("unix://"+path, WithReplyDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			var result float64
+			err := c.Call(context.Background(), "core.echo", []any{i}, &result)
+			if err == nil && int(result) != i {
+				err = errors.New("got the reply of another call")
+			}
+
+			errs <- err
+		}(i)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if mode := <-modes; mode != 0666 {
+		t.Errorf("reply socket mode %o, want 666", mode)
+	}
+
+	leftover, _ := filepath.Glob(filepath.Join(dir, "pgkamtools_*"))
+	if len(leftover) > 0 {
+		t.Errorf("reply sockets not removed: %v", leftover)
+	}
+}
+
+func TestUnixTransportCancel(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kamailio_rpc.sock")
+	serveUnixgram(t, path, func(req map[string]any, from *net.UnixAddr) any {
+		return nil
+	})
+
+	c, err := NewClient("unix://"+path, WithReplyDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = c.Call(ctx, "core.uptime", nil, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("cancel did not unblock the read")
+	}
+}
@@ -0,0 +1,252 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// UACRegFlags are the flags of a uac registration.
+type UACRegFlags int
+
+// flag bits of uac.reg_dump.
+const (
+	UACRegDisabled UACRegFlags = 1
+	UACRegOngoing  UACRegFlags = 2
+	UACRegOnline   UACRegFlags = 4
+	UACRegAuthSent UACRegFlags = 8
+	UACRegInit     UACRegFlags = 16
+)
+
+// String returns the set flags, ie "online|authsent".
+func (f UACRegFlags) String() string {
+	var names []string
+	for _, flag := range []struct {
+		bit  UACRegFlags
+		name string
+	}{
+		{UACRegDisabled, "disabled"},
+		{UACRegOngoing, "ongoing"},
+		{UACRegOnline, "online"},
+		{UACRegAuthSent, "authsent"},
+		{UACRegInit, "init"},
+	} {
+		if f&flag.bit != 0 {
+			names = append(names, flag.name)
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+// State returns a readable state: disabled, registered, registering,
+// init or unregistered.
+func (f UACRegFlags) State() string {
+	switch {
+	case f&UACRegDisabled != 0:
+		return "disabled"
+	case f&UACRegOnline != 0:
+		return "registered"
+	case f&UACRegOngoing != 0:
+		return "registering"
+	case f&UACRegInit != 0:
+		return "init"
+	}
+
+	return "unregistered"
+}
+
+// UACRegistration is one remote registration of the uac module.
+type UACRegistration struct {
+	LocalUUID    string        `json:"l_uuid"`
+	LocalUser    string        `json:"l_username"`
+	LocalDomain  string        `json:"l_domain"`
+	RemoteUser   string        `json:"r_username"`
+	RemoteDomain string        `json:"r_domain"`
+	Realm        string        `json:"realm"`
+	AuthUser     string        `json:"auth_username"`
+	AuthPassword string        `json:"auth_password"`
+	AuthHA1      string        `json:"auth_ha1"`
+	AuthProxy    string        `json:"auth_proxy"`
+	Expires      time.Duration `json:"expires"`
+	Flags        UACRegFlags   `json:"flags"`
+	DiffExpires  time.Duration `json:"diff_expires"`
+	TimerExpires time.Time     `json:"timer_expires"`
+	RegInit      time.Time     `json:"reg_init"`
+	RegDelay     time.Duration `json:"reg_delay"`
+	ContactAddr  string        `json:"contact_addr"`
+	Socket       string        `json:"socket"`
+}
+
+type uacRegJson struct {
+	LocalUUID    string      `json:"l_uuid"`
+	LocalUser    string      `json:"l_username"`
+	LocalDomain  string      `json:"l_domain"`
+	RemoteUser   string      `json:"r_username"`
+	RemoteDomain string      `json:"r_domain"`
+	Realm        string      `json:"realm"`
+	AuthUser     string      `json:"auth_username"`
+	AuthPassword string      `json:"auth_password"`
+	AuthHA1      string      `json:"auth_ha1"`
+	AuthProxy    string      `json:"auth_proxy"`
+	Expires      json.Number `json:"expires"`
+	Flags        json.Number `json:"flags"`
+	DiffExpires  json.Number `json:"diff_expires"`
+	TimerExpires json.Number `json:"timer_expires"`
+	RegInit      json.Number `json:"reg_init"`
+	RegDelay     json.Number `json:"reg_delay"`
+	ContactAddr  string      `json:"contact_addr"`
+	Socket       string      `json:"socket"`
+}
+
+func (r uacRegJson) registration() UACRegistration {
+	return UACRegistration{
+		LocalUUID:    r.LocalUUID,
+		LocalUser:    r.LocalUser,
+		LocalDomain:  r.LocalDomain,
+		RemoteUser:   r.RemoteUser,
+		RemoteDomain: r.RemoteDomain,
+		Realm:        r.Realm,
+		AuthUser:     r.AuthUser,
+		AuthPassword: r.AuthPassword,
+		AuthHA1:      r.AuthHA1,
+		AuthProxy:    r.AuthProxy,
+		Expires:      time.Duration(numberInt(r.Expires)) * time.Second,
+		Flags:        UACRegFlags(numberInt(r.Flags)),
+		DiffExpires:  time.Duration(numberInt(r.DiffExpires)) * time.Second,
+		TimerExpires: unixTime(numberInt(r.TimerExpires)),
+		RegInit:      unixTime(numberInt(r.RegInit)),
+		RegDelay:     time.Duration(numberInt(r.RegDelay)) * time.Second,
+		ContactAddr:  r.ContactAddr,
+		Socket:       r.Socket,
+	}
+}
+
+// Registered reports whether the registration is online.
+func (r UACRegistration) Registered() bool {
+	return r.Flags&UACRegOnline != 0
+}
+
+// UACRegistrations returns the remote registrations (uac.reg_dump).
+func (c *Client) UACRegistrations(ctx context.Context) ([]UACRegistration, error) {
+	list, err := callList[uacRegJson](ctx, c, "uac.reg_dump", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	regs := make([]UACRegistration, 0, len(list))
+	for _, r := range list {
+		regs = append(regs, r.registration())
+	}
+
+	return regs, nil
+}
+
+// UACRegInfo returns one registration (uac.reg_info). key is l_uuid,
+// l_username, r_username or auth_username.
+func (c *Client) UACRegInfo(ctx context.Context, key string, value string) (UACRegistration, error) {
+	var r uacRegJson
+	err := c.Call(ctx, "uac.reg_info", []any{key, value}, &r)
+	return r.registration(), err
+}
+
+// UACRegRefresh reloads a registration from the database by l_uuid
+// (uac.reg_refresh).
+func (c *Client) UACRegRefresh(ctx context.Context, uuid string) error {
+	return c.Call(ctx, "uac.reg_refresh", []any{uuid}, nil)
+}
+
+// UACRegEnable enables a registration, key as for UACRegInfo.
+func (c *Client) UACRegEnable(ctx context.Context, key string, value string) error {
+	return c.Call(ctx, "uac.reg_enable", []any{key, value}, nil)
+}
+
+// UACRegDisable disables a registration, key as for UACRegInfo.
+func (c *Client) UACRegDisable(ctx context.Context, key string, value string) error {
+	return c.Call(ctx, "uac.reg_disable", []any{key, value}, nil)
+}
+
+// UACRegReload reloads all registrations from the database.
+func (c *Client) UACRegReload(ctx context.Context) error {
+	return c.Call(ctx, "uac.reg_reload", nil, nil)
+}
+
+// UACRegAdd adds a registration in memory (uac.reg_add). Expires, Flags,
+// RegDelay and Socket are used along with the names and auth fields.
+func (c *Client) UACRegAdd(ctx context.Context, reg UACRegistration) error {
+	params := []any{
+		uacParam(reg.LocalUUID),
+		uacParam(reg.LocalUser),
+		uacParam(reg.LocalDomain),
+		uacParam(reg.RemoteUser),
+		uacParam(reg.RemoteDomain),
+		uacParam(reg.Realm),
+		uacParam(reg.AuthUser),
+		uacParam(reg.AuthPassword),
+		uacParam(reg.AuthHA1),
+		uacParam(reg.AuthProxy),
+		int(reg.Expires / time.Second),
+		int(reg.Flags),
+		int(reg.RegDelay / time.Second),
+		uacParam(reg.Socket),
+	}
+
+	return c.Call(ctx, "uac.reg_add", params, nil)
+}
+
+// UACRegRemove removes a registration by l_uuid (uac.reg_remove).
+func (c *Client) UACRegRemove(ctx context.Context, uuid string) error {
+	return c.Call(ctx, "uac.reg_remove", []any{uuid}, nil)
+}
+
+// UACNotRegistered returns the enabled registrations that are not online.
+func (c *Client) UACNotRegistered(ctx context.Context) ([]UACRegistration, error) {
+	regs, err := c.UACRegistrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var down []UACRegistration
+	for _, reg := range regs {
+		if reg.Flags&UACRegDisabled == 0 && !reg.Registered() {
+			down = append(down, reg)
+		}
+	}
+
+	return down, nil
+}
+
+// uacParam returns "." for empty values, which uac.reg_add takes as empty.
+func uacParam(value string) string {
+	if value == "" {
+		return "."
+	}
+
+	return value
+}
@@ -0,0 +1,419 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// DefaultUsrlocTable is the usrloc table used by the package level Reg
+// functions.
+const DefaultUsrlocTable = "location"
+
+// usrloc prints unset contact fields as this value.
+const usrlocNotSet = "[not set]"
+
+// Registration is an AoR with its contacts from ul.dump. Table is the
+// usrloc domain (ie location).
+type Registration struct {
+	Table    string    `json:"table"`
+	AoR      string    `json:"aor"`
+	HashID   uint32    `json:"hash_id"`
+	Contacts []Contact `json:"contacts"`
+}
+
+// Contact is a typed usrloc contact. Expires is the absolute expiry time,
+// worked out from the seconds left when the dump was taken; it is zero for
+// permanent (static) contacts.
+type Contact struct {
+	Address       string        `json:"address"`
+	URI           *SIPURI       `json:"uri,omitempty"`
+	Expires       time.Time     `json:"expires"`
+	Permanent     bool          `json:"permanent"`
+	Q             float64       `json:"q"`
+	CallID        string        `json:"call_id"`
+	CSeq          int           `json:"cseq"`
+	UserAgent     string        `json:"user_agent"`
+	Received      *SIPURI       `json:"received,omitempty"`
+	Path          []SIPURI      `json:"path,omitempty"`
+	Socket        *SocketAddr   `json:"socket,omitempty"`
+	RawReceived   string        `json:"raw_received,omitempty"`
+	RawPath       string        `json:"raw_path,omitempty"`
+	RawSocket     string        `json:"raw_socket,omitempty"`
+	Methods       int           `json:"methods"`
+	Ruid          string        `json:"ruid"`
+	Instance      string        `json:"instance,omitempty"`
+	RegID         int           `json:"reg_id"`
+	ServerID      int           `json:"server_id"`
+	TcpconnID     int           `json:"tcpconn_id"`
+	Keepalive     int           `json:"keepalive"`
+	LastKeepalive time.Time     `json:"last_keepalive"`
+	KARoundtrip   time.Duration `json:"ka_roundtrip"`
+	LastModified  time.Time     `json:"last_modified"`
+}
+
+type usrlocContact struct {
+	Address       string          `json:"Address"`
+	Expires       json.RawMessage `json:"Expires"`
+	Q             json.Number     `json:"Q"`
+	CallID        string          `json:"Call-ID"`
+	CSeq          json.Number     `json:"CSeq"`
+	UserAgent     string          `json:"User-Agent"`
+	Received      string          `json:"Received"`
+	Path          string          `json:"Path"`
+	Socket        string          `json:"Socket"`
+	Methods       json.Number     `json:"Methods"`
+	Ruid          string          `json:"Ruid"`
+	Instance      string          `json:"Instance"`
+	RegID         json.Number     `json:"Reg-Id"`
+	ServerID      json.Number     `json:"Server-Id"`
+	TcpconnID     json.Number     `json:"Tcpconn-Id"`
+	Keepalive     json.Number     `json:"Keepalive"`
+	LastKeepalive json.Number     `json:"Last-Keepalive"`
+	KARoundtrip   json.Number     `json:"KA-Roundtrip"`
+	LastModified  json.Number     `json:"Last-Modified"`
+}
+
+type usrlocAoR struct {
+	AoR      string      `json:"AoR"`
+	HashID   json.Number `json:"HashID"`
+	Contacts []struct {
+		Contact usrlocContact `json:"Contact"`
+	} `json:"Contacts"`
+}
+
+type usrlocDump struct {
+	Domains []struct {
+		Domain struct {
+			Domain string `json:"Domain"`
+			AoRs   []struct {
+				Info usrlocAoR `json:"Info"`
+			} `json:"AoRs"`
+		} `json:"Domain"`
+	} `json:"Domains"`
+}
+
+// StaticContact is a contact for RegAdd. Expires of zero adds a permanent
+// contact.
+type StaticContact struct {
+	AoR     string
+	Contact string
+	Expires time.Duration
+	Q       float64
+	Path    string
+	Flags   int
+	CFlags  int
+	Methods int
+}
+
+func (c *Client) RegDeleteAOR(ctx context.Context, tableval string, aorval string) (bool, error) {
+	err := c.Call(ctx, "ul.rm", []any{tableval, aorval}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Client) RegGetAOR(ctx context.Context, tableval string, aorval string) (string, error) {
+	aorresult, err := c.CallRaw(ctx, "ul.lookup", []any{tableval, aorval})
+	if err != nil {
+		return "", err
+	}
+
+	parsedval := gjson.Get(aorresult, "result.Contacts.#.Contact.{Address,Expires,UA}")
+	return parsedval.String(), nil
+}
+
+func (c *Client) RegsGet(ctx context.Context) (string, error) {
+	return c.CallRaw(ctx, "ul.dump", nil)
+}
+
+// RegLookup returns the typed ul.lookup result for an AoR.
+func (c *Client) RegLookup(ctx context.Context, tableval string, aorval string) (Registration, error) {
+	var aor usrlocAoR
+	if err := c.Call(ctx, "ul.lookup", []any{tableval, aorval}, &aor); err != nil {
+		return Registration{}, err
+	}
+
+	if aor.AoR == "" {
+		aor.AoR = aorval
+	}
+
+	return aor.registration(tableval, time.Now()), nil
+}
+
+// RegAdd adds a contact with ul.add, ie a static registration for a trunk
+// or a PBX that cannot register.
+func (c *Client) RegAdd(ctx context.Context, tableval string, contact StaticContact) error {
+	path := contact.Path
+	if path == "" {
+		// ul.add takes "0" for no path.
+		path = "0"
+	}
+
+	params := []any{
+		tableval,
+		contact.AoR,
+		contact.Contact,
+		int(contact.Expires / time.Second),
+		contact.Q,
+		path,
+		contact.Flags,
+		contact.CFlags,
+		contact.Methods,
+	}
+
+	return c.Call(ctx, "ul.add", params, nil)
+}
+
+// RegRemoveContact removes a single contact of an AoR.
+func (c *Client) RegRemoveContact(ctx context.Context, tableval string, aorval string, contactval string) error {
+	return c.Call(ctx, "ul.rm_contact", []any{tableval, aorval, contactval}, nil)
+}
+
+// RegFlush writes the usrloc cache to the database.
+func (c *Client) RegFlush(ctx context.Context) error {
+	return c.Call(ctx, "ul.flush", nil, nil)
+}
+
+// RegsGetBrief returns ul.dump brief, which only has the AoRs with contact
+// addresses and expires.
+func (c *Client) RegsGetBrief(ctx context.Context) ([]Registration, error) {
+	var dump usrlocDump
+	if err := c.Call(ctx, "ul.dump", []any{"brief"}, &dump); err != nil {
+		return nil, err
+	}
+
+	return dump.registrations(time.Now()), nil
+}
+
+// RegDbUsers returns the number of unique users in the usrloc database
+// table.
+func (c *Client) RegDbUsers(ctx context.Context, tableval string) (int64, error) {
+	return c.callCount(ctx, "ul.db_users", "count", tableval)
+}
+
+// RegDbContacts returns the number of contacts in the usrloc database table.
+func (c *Client) RegDbContacts(ctx context.Context, tableval string) (int64, error) {
+	return c.callCount(ctx, "ul.db_contacts", "count", tableval)
+}
+
+// RegDbExpiredContacts returns the number of expired contacts in the usrloc
+// database table.
+func (c *Client) RegDbExpiredContacts(ctx context.Context, tableval string) (int64, error) {
+	return c.callCount(ctx, "ul.db_expired_contacts", "count", tableval)
+}
+
+// RegDbSync writes the in-memory contacts of the table to the usrloc
+// database (ul.db_sync), for db_mode setups that write back lazily.
+func (c *Client) RegDbSync(ctx context.Context, tableval string) error {
+	return c.Call(ctx, "ul.db_sync", []any{tableval}, nil)
+}
+
+// Registrations returns ul.dump as typed registrations.
+func (c *Client) Registrations(ctx context.Context) ([]Registration, error) {
+	var dump usrlocDump
+	if err := c.Call(ctx, "ul.dump", nil, &dump); err != nil {
+		return nil, err
+	}
+
+	return dump.registrations(time.Now()), nil
+}
+
+// ParseRegistrations parses the json of RegsGet.
+func ParseRegistrations(jsonval string) ([]Registration, error) {
+	resp, err := parseResponse([]byte(jsonval))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	var dump usrlocDump
+	if err := decodeResult(resp.Result, &dump); err != nil {
+		return nil, err
+	}
+
+	return dump.registrations(time.Now()), nil
+}
+
+func (d usrlocDump) registrations(now time.Time) []Registration {
+	var regs []Registration
+	for _, domain := range d.Domains {
+		for _, aor := range domain.Domain.AoRs {
+			regs = append(regs, aor.Info.registration(domain.Domain.Domain, now))
+		}
+	}
+
+	return regs
+}
+
+func (a usrlocAoR) registration(table string, now time.Time) Registration {
+	reg := Registration{
+		Table:  table,
+		AoR:    a.AoR,
+		HashID: uint32(numberInt(a.HashID)),
+	}
+
+	for _, c := range a.Contacts {
+		reg.Contacts = append(reg.Contacts, c.Contact.contact(now))
+	}
+
+	return reg
+}
+
+func (c usrlocContact) contact(now time.Time) Contact {
+	contact := Contact{
+		Address:       c.Address,
+		Q:             numberFloat(c.Q),
+		CallID:        c.CallID,
+		CSeq:          int(numberInt(c.CSeq)),
+		UserAgent:     notSet(c.UserAgent),
+		Methods:       int(numberInt(c.Methods)),
+		Ruid:          notSet(c.Ruid),
+		Instance:      notSet(c.Instance),
+		RawReceived:   notSet(c.Received),
+		RawPath:       notSet(c.Path),
+		RawSocket:     notSet(c.Socket),
+		RegID:         int(numberInt(c.RegID)),
+		ServerID:      int(numberInt(c.ServerID)),
+		TcpconnID:     int(numberInt(c.TcpconnID)),
+		Keepalive:     int(numberInt(c.Keepalive)),
+		LastKeepalive: unixTime(numberInt(c.LastKeepalive)),
+		KARoundtrip:   time.Duration(numberInt(c.KARoundtrip)) * time.Microsecond,
+		LastModified:  unixTime(numberInt(c.LastModified)),
+	}
+
+	if uri, err := ParseSIPURI(c.Address); err == nil {
+		contact.URI = &uri
+	}
+
+	// expires is the seconds left, or permanent / expired / deleted.
+	var expires json.Number
+	var state string
+	if err := json.Unmarshal(c.Expires, &expires); err == nil {
+		contact.Expires = now.Add(time.Duration(numberInt(expires)) * time.Second)
+	} else if err := json.Unmarshal(c.Expires, &state); err == nil {
+		switch strings.ToLower(state) {
+		case "permanent":
+			contact.Permanent = true
+		default:
+			contact.Expires = now
+		}
+	}
+
+	// values that don't parse are left nil, the raw strings are kept.
+	if contact.RawReceived != "" {
+		if uri, err := ParseSIPURI(contact.RawReceived); err == nil {
+			contact.Received = &uri
+		}
+	}
+
+	if contact.RawPath != "" {
+		if uris, err := ParseSIPURIList(contact.RawPath); err == nil {
+			contact.Path = uris
+		}
+	}
+
+	if contact.RawSocket != "" {
+		if addr, err := ParseSocket(contact.RawSocket); err == nil {
+			contact.Socket = &addr
+		}
+	}
+
+	return contact
+}
+
+// IsExpired reports whether the contact has expired. Permanent contacts
+// never expire.
+func (c Contact) IsExpired() bool {
+	return !c.Permanent && !time.Now().Before(c.Expires)
+}
+
+// TimeToExpiry returns the time left before the contact expires, zero when
+// it has expired and the largest duration for permanent contacts.
+func (c Contact) TimeToExpiry() time.Duration {
+	if c.Permanent {
+		return time.Duration(math.MaxInt64)
+	}
+
+	left := time.Until(c.Expires)
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+func notSet(value string) string {
+	if value == usrlocNotSet {
+		return ""
+	}
+
+	return value
+}
+
+// numberInt also accepts floats in scientific notation, which is how
+// kamailio prints some timestamps.
+func numberInt(n json.Number) int64 {
+	if n == "" {
+		return 0
+	}
+
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return 0
+	}
+
+	return int64(math.Round(f))
+}
+
+func numberFloat(n json.Number) float64 {
+	f, _ := n.Float64()
+	return f
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
@@ -0,0 +1,384 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type RegEventType int
+
+const (
+	RegEventNew RegEventType = iota
+	RegEventRefreshed
+	RegEventExpired
+	RegEventMoved
+	RegEventUserAgentChanged
+	RegEventSiteDown
+)
+
+func (t RegEventType) String() string {
+	switch t {
+	case RegEventNew:
+		return "new"
+	case RegEventRefreshed:
+		return "refreshed"
+	case RegEventExpired:
+		return "expired"
+	case RegEventMoved:
+		return "moved"
+	case RegEventUserAgentChanged:
+		return "user_agent_changed"
+	case RegEventSiteDown:
+		return "site_down"
+	}
+
+	return "unknown"
+}
+
+func (t RegEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// RegEvent is a change of one contact between two polls. For
+// RegEventSiteDown, AoR and Contact are empty and Host / Count tell which
+// source address lost all of its contacts.
+type RegEvent struct {
+	Type     RegEventType `json:"type"`
+	Time     time.Time    `json:"time"`
+	Table    string       `json:"table,omitempty"`
+	AoR      string       `json:"aor,omitempty"`
+	Contact  Contact      `json:"contact"`
+	Previous *Contact     `json:"previous,omitempty"`
+	Host     string       `json:"host,omitempty"`
+	Count    int          `json:"count,omitempty"`
+}
+
+// RegChurn counts the events of one AoR since the watcher started.
+type RegChurn struct {
+	AoR              string    `json:"aor"`
+	New              int       `json:"new"`
+	Refreshed        int       `json:"refreshed"`
+	Expired          int       `json:"expired"`
+	Moved            int       `json:"moved"`
+	UserAgentChanged int       `json:"user_agent_changed"`
+	LastEvent        time.Time `json:"last_event"`
+}
+
+// Flaps is the number of times the AoR registered or lost a contact.
+func (c RegChurn) Flaps() int {
+	return c.New + c.Expired + c.Moved
+}
+
+// RegWatcher polls ul.dump and reports contact changes. Set the fields
+// before calling Run or Watch.
+type RegWatcher struct {
+	client *Client
+
+	// Interval between polls, 60 seconds if zero.
+	Interval time.Duration
+
+	// SiteDropMin reports RegEventSiteDown when a source host that had at
+	// least this many contacts loses all of them in one poll. Zero
+	// disables it.
+	SiteDropMin int
+
+	// EmitInitial reports every contact of the first poll as new.
+	EmitInitial bool
+
+	// OnEvent is called for each event by Run.
+	OnEvent func(RegEvent)
+
+	// OnError is called when a poll fails. The watcher keeps polling.
+	OnError func(error)
+
+	mu    sync.Mutex
+	last  []Registration
+	seen  bool
+	churn map[string]*RegChurn
+}
+
+func (c *Client) NewRegWatcher(interval time.Duration) *RegWatcher {
+	return &RegWatcher{
+		client:   c,
+		Interval: interval,
+	}
+}
+
+// Run polls until ctx is done and calls OnEvent for every change.
+func (w *RegWatcher) Run(ctx context.Context) error {
+	return pollLoop(ctx, w.interval(), w.Poll, w.OnEvent, w.OnError)
+}
+
+// Watch runs the watcher in a goroutine and delivers events on the returned
+// channel, which is closed when ctx is done. OnEvent is not used.
+func (w *RegWatcher) Watch(ctx context.Context) <-chan RegEvent {
+	return watchLoop(ctx, w.interval(), 64, w.Poll, w.OnError)
+}
+
+func (w *RegWatcher) interval() time.Duration {
+	if w.Interval <= 0 {
+		return 60 * time.Second
+	}
+
+	return w.Interval
+}
+
+// Poll fetches ul.dump once, updates the churn counters and returns the
+// changes since the previous poll.
+func (w *RegWatcher) Poll(ctx context.Context) ([]RegEvent, error) {
+	dump, err := w.client.RegsGet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	regs, err := ParseRegistrations(dump)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []RegEvent
+	if w.seen || w.EmitInitial {
+		events = DiffRegistrations(w.last, regs, w.SiteDropMin)
+	}
+
+	w.last = regs
+	w.seen = true
+	if w.churn == nil {
+		w.churn = map[string]*RegChurn{}
+	}
+
+	for _, event := range events {
+		if event.AoR == "" {
+			continue
+		}
+
+		churn, ok := w.churn[event.AoR]
+		if !ok {
+			churn = &RegChurn{AoR: event.AoR}
+			w.churn[event.AoR] = churn
+		}
+
+		churn.LastEvent = event.Time
+		switch event.Type {
+		case RegEventNew:
+			churn.New++
+		case RegEventRefreshed:
+			churn.Refreshed++
+		case RegEventExpired:
+			churn.Expired++
+		case RegEventMoved:
+			churn.Moved++
+		case RegEventUserAgentChanged:
+			churn.UserAgentChanged++
+		}
+	}
+
+	return events, nil
+}
+
+// Churn returns a copy of the counters per AoR.
+func (w *RegWatcher) Churn() map[string]RegChurn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	churn := make(map[string]RegChurn, len(w.churn))
+	for aor, counters := range w.churn {
+		churn[aor] = *counters
+	}
+
+	return churn
+}
+
+// Flapping returns the AoRs with at least minFlaps flaps, most first.
+func (w *RegWatcher) Flapping(minFlaps int) []RegChurn {
+	var flapping []RegChurn
+	for _, churn := range w.Churn() {
+		if churn.Flaps() >= minFlaps {
+			flapping = append(flapping, churn)
+		}
+	}
+
+	sort.Slice(flapping, func(i, j int) bool {
+		if flapping[i].Flaps() != flapping[j].Flaps() {
+			return flapping[i].Flaps() > flapping[j].Flaps()
+		}
+
+		return flapping[i].AoR < flapping[j].AoR
+	})
+
+	return flapping
+}
+
+// ResetChurn clears the counters.
+func (w *RegWatcher) ResetChurn() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.churn = nil
+}
+
+type regContactRef struct {
+	table   string
+	aor     string
+	contact Contact
+}
+
+// contacts are matched by instance when they have one, else by address.
+func regContactKey(table string, aor string, contact Contact) string {
+	id := contact.Instance
+	if id == "" {
+		id = contact.Address
+	}
+
+	return table + "\x00" + aor + "\x00" + id
+}
+
+// ContactSourceHost is the address a contact registered from: the received
+// host behind NAT, or the contact host.
+func ContactSourceHost(contact Contact) string {
+	if contact.Received != nil {
+		return contact.Received.Host
+	}
+
+	if contact.URI != nil {
+		return contact.URI.Host
+	}
+
+	return ""
+}
+
+func activeContacts(regs []Registration) (map[string]regContactRef, []string) {
+	contacts := map[string]regContactRef{}
+	var keys []string
+	for _, reg := range regs {
+		for _, contact := range reg.Contacts {
+			if contact.IsExpired() {
+				continue
+			}
+
+			key := regContactKey(reg.Table, reg.AoR, contact)
+			if _, ok := contacts[key]; !ok {
+				keys = append(keys, key)
+			}
+
+			contacts[key] = regContactRef{table: reg.Table, aor: reg.AoR, contact: contact}
+		}
+	}
+
+	sort.Strings(keys)
+	return contacts, keys
+}
+
+// DiffRegistrations compares two ul.dump snapshots. Expired contacts count
+// as gone. siteDropMin works as RegWatcher.SiteDropMin.
+func DiffRegistrations(previous []Registration, current []Registration, siteDropMin int) []RegEvent {
+	now := time.Now()
+	before, beforeKeys := activeContacts(previous)
+	after, afterKeys := activeContacts(current)
+
+	var events []RegEvent
+	event := func(eventType RegEventType, ref regContactRef, prev *Contact) {
+		events = append(events, RegEvent{
+			Type:     eventType,
+			Time:     now,
+			Table:    ref.table,
+			AoR:      ref.aor,
+			Contact:  ref.contact,
+			Previous: prev,
+		})
+	}
+
+	for _, key := range afterKeys {
+		ref := after[key]
+		old, ok := before[key]
+		if !ok {
+			event(RegEventNew, ref, nil)
+			continue
+		}
+
+		prev := old.contact
+		if ContactSourceHost(prev) != ContactSourceHost(ref.contact) || receivedPort(prev) != receivedPort(ref.contact) {
+			event(RegEventMoved, ref, &prev)
+		}
+
+		if prev.UserAgent != ref.contact.UserAgent {
+			event(RegEventUserAgentChanged, ref, &prev)
+		}
+
+		if ref.contact.LastModified.After(prev.LastModified) || ref.contact.CSeq != prev.CSeq {
+			event(RegEventRefreshed, ref, &prev)
+		}
+	}
+
+	hostsBefore := map[string]int{}
+	hostsAfter := map[string]int{}
+	for _, ref := range before {
+		hostsBefore[ContactSourceHost(ref.contact)]++
+	}
+
+	for _, ref := range after {
+		hostsAfter[ContactSourceHost(ref.contact)]++
+	}
+
+	for _, key := range beforeKeys {
+		if _, ok := after[key]; !ok {
+			event(RegEventExpired, before[key], nil)
+		}
+	}
+
+	if siteDropMin > 0 {
+		var hosts []string
+		for host, count := range hostsBefore {
+			if host != "" && count >= siteDropMin && hostsAfter[host] == 0 {
+				hosts = append(hosts, host)
+			}
+		}
+
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			events = append(events, RegEvent{
+				Type:  RegEventSiteDown,
+				Time:  now,
+				Host:  host,
+				Count: hostsBefore[host],
+			})
+		}
+	}
+
+	return events
+}
+
+func receivedPort(contact Contact) int {
+	if contact.Received != nil {
+		return contact.Received.Port
+	}
+
+	return 0
+}
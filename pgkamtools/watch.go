@@ -0,0 +1,80 @@
+/*
+
+Copyright (C) 2021, 2024 Fred Posner. All Rights Reserved.
+Copyright (C) 2021, 2024 The Palner Group, Inc. All Rights Reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package pgkamtools
+
+import (
+	"context"
+	"time"
+)
+
+// pollLoop calls poll every interval until ctx is done and hands each event
+// to emit. Failed polls go to onError and the loop keeps polling. emit and
+// onError may be nil.
+func pollLoop[E any](ctx context.Context, interval time.Duration, poll func(context.Context) ([]E, error), emit func(E), onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		events, err := poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if onError != nil {
+				onError(err)
+			}
+		}
+
+		if emit != nil {
+			for _, event := range events {
+				emit(event)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchLoop runs pollLoop in a goroutine and delivers the events on the
+// returned channel, which is closed when ctx is done.
+func watchLoop[E any](ctx context.Context, interval time.Duration, size int, poll func(context.Context) ([]E, error), onError func(error)) <-chan E {
+	events := make(chan E, size)
+	go func() {
+		defer close(events)
+		pollLoop(ctx, interval, poll, func(event E) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}, onError)
+	}()
+
+	return events
+}